@@ -0,0 +1,164 @@
+package bencode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+type benchFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type benchInfo struct {
+	PieceLength int         `bencode:"piece length"`
+	Pieces      []byte      `bencode:"pieces"`
+	Name        string      `bencode:"name"`
+	Length      int         `bencode:"length"`
+	Files       []benchFile `bencode:"files"`
+}
+
+type benchMetaInfo struct {
+	Info         benchInfo  `bencode:"info"`
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list"`
+	CreationDate int        `bencode:"creation date"`
+	Comment      string     `bencode:"comment"`
+}
+
+// a BEP 10 extension handshake, the kind of message decoded for every peer
+var benchExtHandshake = []byte("d1:md11:ut_metadatai1e6:ut_pexi2ee1:pi6881e" +
+	"4:reqqi250e1:v12:btget 0.0.0113:metadata_sizei31235ee")
+
+func loadBenchTorrent(b *testing.B) []byte {
+	data, err := ioutil.ReadFile("../testdata/ubuntu-17.10.1-desktop-amd64.iso.torrent")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkUnmarshalMetaInfo(b *testing.B) {
+	data := loadBenchTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m benchMetaInfo
+		if err := Unmarshal(data, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMetaInfoStream(b *testing.B) {
+	data := loadBenchTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m benchMetaInfo
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMetaInfoAlias(b *testing.B) {
+	data := loadBenchTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m benchMetaInfo
+		dec := NewBytesDecoder(data)
+		dec.AliasInput()
+		if err := dec.Decode(&m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValueMetaInfoPieces(b *testing.B) {
+	data := loadBenchTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v, err := ParseValue(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		info, _ := v.Get("info")
+		pieces, _ := info.Get("pieces")
+		if _, err := pieces.Bytes(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalExtHandshake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m map[string]interface{}
+		if err := Unmarshal(benchExtHandshake, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValueExtHandshake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v, err := ParseValue(benchExtHandshake)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m, _ := v.Get("m")
+		if _, ok := m.Get("ut_metadata"); !ok {
+			b.Fatal("ut_metadata not found")
+		}
+	}
+}
+
+// a multi-file torrent with many small files, where per-struct work in the
+// encoder and decoder dominates
+func manyFilesMetaInfo() benchMetaInfo {
+	m := benchMetaInfo{
+		Info: benchInfo{
+			PieceLength: 1 << 18,
+			Pieces:      make([]byte, 20*100),
+			Name:        "many",
+		},
+		Announce: "http://tracker.example.com/announce",
+	}
+	for i := 0; i < 5000; i++ {
+		m.Info.Files = append(m.Info.Files, benchFile{
+			Length: i * 1000,
+			Path:   []string{"dir", "file"},
+		})
+	}
+	return m
+}
+
+func BenchmarkMarshalManyFiles(b *testing.B) {
+	m := manyFilesMetaInfo()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalManyFiles(b *testing.B) {
+	data, err := Marshal(manyFilesMetaInfo())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var m benchMetaInfo
+		if err := Unmarshal(data, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
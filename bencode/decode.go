@@ -8,42 +8,179 @@ import (
 	"io"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
-func Unmarshal(data []byte, v interface{}) (err error) {
+// Unmarshaler is the interface implemented by types that can unmarshal a
+// bencode description of themselves. The input is the raw encoding of a
+// single value. It may alias the decoder's input, so UnmarshalBencode must
+// copy the data if it wishes to retain it after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+func Unmarshal(data []byte, v interface{}) error {
+	err := NewBytesDecoder(data).Decode(v)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A Decoder reads and decodes consecutive bencode values from an input stream.
+type Decoder struct {
+	us unmarshalState
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{us: unmarshalState{r: bufio.NewReader(r), limits: DefaultLimits}}
+}
+
+// NewBytesDecoder returns a Decoder that reads the values in data directly,
+// without the buffering a Decoder over an io.Reader needs.
+func NewBytesDecoder(data []byte) *Decoder {
+	return &Decoder{us: unmarshalState{data: data, limits: DefaultLimits}}
+}
+
+// Decode reads the next bencode value from the stream and stores it in the
+// value pointed to by v. It returns io.EOF if the stream ends before a value
+// starts and io.ErrUnexpectedEOF if it ends in the middle of one.
+func (d *Decoder) Decode(v interface{}) (err error) {
 	defer handlePanic(&err)
 	value := reflect.ValueOf(v)
 	if value.Kind() != reflect.Ptr {
-		panic(fmt.Errorf("cannot unmarshal into non-ptr type: %t", v))
+		panic(fmt.Errorf("cannot unmarshal into non-ptr type: %T", v))
+	}
+	if value.IsNil() {
+		panic(fmt.Errorf("cannot unmarshal into nil %T", v))
 	}
 
-	us := unmarshalState{*bufio.NewReader(bytes.NewReader(data))}
-	us.unmarshal(value)
+	if err := d.us.more(); err != nil {
+		return err
+	}
+	d.us.reset()
+	d.us.unmarshal(value)
 	return
 }
 
+// InputOffset returns the number of bytes consumed by the decoder so far.
+// It is the offset just past the end of the most recently decoded value,
+// so the size of a value is the difference of offsets around its Decode.
+func (d *Decoder) InputOffset() int64 {
+	return d.us.off
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+func (d *Decoder) Buffered() io.Reader {
+	if d.us.r == nil {
+		return bytes.NewReader(d.us.data[d.us.off:])
+	}
+	n := d.us.r.Buffered()
+	b, _ := d.us.r.Peek(n)
+	return bytes.NewReader(b)
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when a dict
+// being decoded into a struct has a key that matches no struct field.
+func (d *Decoder) DisallowUnknownFields() {
+	d.us.disallowUnknown = true
+}
+
+// SetLimits sets the limits that apply to each value the Decoder decodes.
+func (d *Decoder) SetLimits(l Limits) {
+	d.us.limits = l
+}
+
+// AliasInput makes decoded []byte values, RawMessages and Values refer to
+// the input instead of copying it. It only has an effect on Decoders from
+// NewBytesDecoder, and the input must not be modified while decoded values
+// are in use.
+func (d *Decoder) AliasInput() {
+	d.us.alias = d.us.r == nil
+}
+
+// DisallowNonCanonical causes the Decoder to reject input that is valid but
+// not canonical bencode: integers and string lengths with leading zeros,
+// negative zero, and dict keys that are unsorted or repeated. Decoding
+// such input and encoding it again would not reproduce the same bytes.
+func (d *Decoder) DisallowNonCanonical() {
+	d.us.strict = true
+}
+
 type unmarshalState struct {
-	bufio.Reader
+	// input is read from r, or directly from data if r is nil
+	r     *bufio.Reader
+	data  []byte
+	off   int64
+	alias bool
+
+	// consumed bytes are appended to rec while recording
+	rec       []byte
+	recording bool
+
+	// valueOff is the offset of the value currently being decoded
+	valueOff int64
+	path     []pathElem
+	scratch  []byte
+
+	limits   Limits
+	start    int64
+	depth    int
+	elements int64
+
+	disallowUnknown bool
+	strict          bool
+}
+
+func (us *unmarshalState) reset() {
+	us.path = us.path[:0]
+	us.start = us.off
+	us.depth = 0
+	us.elements = 0
 }
 
 func (us *unmarshalState) unmarshal(v reflect.Value) {
-	v = indirect(v)
+	us.valueOff = us.off
+	u, v := indirect(v)
+	if u != nil {
+		raw := us.rawValue()
+		if us.alias {
+			switch u := u.(type) {
+			case *RawMessage:
+				*u = raw
+				return
+			case *Value:
+				*u = Value{raw}
+				return
+			}
+		}
+		err := u.UnmarshalBencode(raw)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 
+	us.countElement()
 	b := us.peekByte()
 	switch {
 	case b == 'l':
 		us.skipByte()
+		us.enter()
 		us.unmarshalList(v)
+		us.depth--
 	case b == 'i':
 		us.skipByte()
 		us.unmarshalInt(v)
 	case b == 'd':
 		us.skipByte()
+		us.enter()
 		us.unmarshalDict(v)
-	case '0' <= b && b <= '9':
+		us.depth--
+	case isDigit(b):
 		us.unmarshalBytes(v)
 	default:
-		panic(fmt.Errorf("unexpected character %v", b))
+		panic(us.syntaxError(fmt.Sprintf("unexpected character %q", b)))
 	}
 }
 
@@ -74,7 +211,7 @@ func (us *unmarshalState) unmarshalList(v reflect.Value) {
 		}
 
 	default:
-		panic(typeMismatch("list", v))
+		panic(us.typeError("list", "", v))
 	}
 
 	for i := 0; ; i++ {
@@ -82,48 +219,45 @@ func (us *unmarshalState) unmarshalList(v reflect.Value) {
 			us.skipByte()
 			break
 		}
+		us.pushIndex(i)
 		unmarshalElem(i)
+		us.popPath()
 	}
 
 }
 
 func (us *unmarshalState) unmarshalInt(v reflect.Value) {
-	data := us.readStringUntil('e')
+	data := us.readInt()
 
 	switch v.Kind() {
 	case reflect.Interface:
-		n, err := strconv.ParseInt(data, 10, 0)
-		if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
-			un, err := strconv.ParseUint(data, 10, 0)
-			if err != nil {
-				panic(err)
-			}
+		if n, err := strconv.ParseInt(data, 10, 64); err == nil {
+			v.Set(reflect.ValueOf(n))
+		} else if un, err := strconv.ParseUint(data, 10, 64); err == nil {
 			v.Set(reflect.ValueOf(un))
-			break
-		} else if err != nil {
-			panic(err)
+		} else {
+			panic(us.typeError("integer", data, v))
 		}
-		v.Set(reflect.ValueOf(n))
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		n, err := strconv.ParseInt(data, 10, 0)
-		if err != nil {
-			panic(err)
-		}
-		if v.OverflowInt(n) {
-			panic(fmt.Errorf("%v overflows int", n))
+		n, err := strconv.ParseInt(data, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			panic(us.typeError("integer", data, v))
 		}
 		v.SetInt(n)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		n, err := strconv.ParseUint(data, 10, 0)
-		if err != nil {
-			panic(err)
-		}
-		if v.OverflowUint(n) {
-			panic(fmt.Errorf("%v overflows uint", n))
+		n, err := strconv.ParseUint(data, 10, 64)
+		if err != nil || v.OverflowUint(n) {
+			panic(us.typeError("integer", data, v))
 		}
 		v.SetUint(n)
+	case reflect.Bool:
+		// flags are encoded as i0e and i1e
+		if data != "0" && data != "1" {
+			panic(us.typeError("integer", data, v))
+		}
+		v.SetBool(data == "1")
 	default:
-		panic(typeMismatch("integer", v))
+		panic(us.typeError("integer", "", v))
 	}
 }
 
@@ -136,7 +270,7 @@ func (us *unmarshalState) unmarshalDict(v reflect.Value) {
 	case reflect.Struct:
 		us.unmarshalDict2Struct(v)
 	default:
-		panic(typeMismatch("dict", v))
+		panic(us.typeError("dict", "", v))
 	}
 }
 
@@ -147,62 +281,104 @@ func (us *unmarshalState) unmarshalDict2Interface(v reflect.Value) {
 }
 
 func (us *unmarshalState) unmarshalDict2Map(v reflect.Value) {
-	if v.Type().Key().Kind() != reflect.String {
-		panic(errors.New("map keys must be of type string"))
+	kt := v.Type().Key()
+	if !canUnmarshalKey(kt) {
+		panic(us.typeError("dict", "", v))
 	}
 
 	if v.IsNil() {
 		v.Set(reflect.MakeMap(v.Type()))
 	}
 
-	for {
+	var prev []byte
+	for i := 0; ; i++ {
 		if us.peekByte() == 'e' {
 			us.skipByte()
 			break
 		}
 
-		var key string
-		us.unmarshal(reflect.ValueOf(&key))
+		key := us.readKey(i, prev)
+		prev = key
 
+		us.pushKey(key)
+		kv := us.unmarshalKey(key, kt)
 		val := reflect.Indirect(reflect.New(v.Type().Elem()))
 		us.unmarshal(val)
+		us.popPath()
 
-		v.SetMapIndex(reflect.ValueOf(key), val)
+		v.SetMapIndex(kv, val)
 	}
 }
 
 func (us *unmarshalState) unmarshalDict2Struct(v reflect.Value) {
-	fields := structFields(v)
-	for {
+	fields := cachedTypeFields(v.Type())
+	seen := make([]bool, len(fields.list))
+	var prev []byte
+	for n := 0; ; n++ {
 		if us.peekByte() == 'e' {
 			us.skipByte()
 			break
 		}
 
-		var key string
-		us.unmarshal(reflect.ValueOf(&key))
-
-		if field, ok := fields[key]; ok {
-			us.unmarshal(field)
+		key := us.readKey(n, prev)
+		prev = key
+
+		us.pushKey(key)
+		if i, ok := fields.byName[string(key)]; ok {
+			f := fields.list[i]
+			seen[i] = true
+			fv := fieldByIndexAlloc(v, f.index)
+			if f.asString && isDigit(us.peekByte()) {
+				us.unmarshalIntString(fv)
+			} else {
+				us.unmarshal(fv)
+			}
+		} else if us.disallowUnknown {
+			panic(fmt.Errorf("unknown key %q for %v%s", key, v.Type(), us.pathSuffix()))
 		} else {
-			var dummy interface{}
-			us.unmarshal(reflect.ValueOf(&dummy))
-			dummy = nil
+			us.skipValue()
+		}
+		us.popPath()
+	}
+
+	for i, f := range fields.list {
+		if f.required && !seen[i] {
+			panic(fmt.Errorf("missing required key %q for %v%s", f.name, v.Type(), us.pathSuffix()))
 		}
 	}
 }
 
-func (us *unmarshalState) unmarshalBytes(v reflect.Value) {
-	lenStr := us.readStringUntil(':')
-	length, err := strconv.ParseUint(lenStr, 10, 0)
-	if err != nil {
-		panic(err)
+// unmarshalIntString decodes a string holding a decimal integer into an
+// integer field, or a pointer to one, tagged with the string option.
+func (us *unmarshalState) unmarshalIntString(v reflect.Value) {
+	var s string
+	us.unmarshal(reflect.ValueOf(&s))
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			panic(us.typeError("bytes", strconv.Quote(s), v))
+		}
+		v.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			panic(us.typeError("bytes", strconv.Quote(s), v))
+		}
+		v.SetUint(n)
 	}
+}
 
-	bytes := make([]byte, length)
-	_, err = io.ReadFull(us, bytes)
-	if err != nil {
-		panic(err)
+func (us *unmarshalState) unmarshalBytes(v reflect.Value) {
+	bytes := us.next(us.readLength())
+	if us.r == nil && !us.alias && v.Kind() != reflect.String {
+		bytes = append([]byte(nil), bytes...)
 	}
 
 	switch v.Kind() {
@@ -212,66 +388,320 @@ func (us *unmarshalState) unmarshalBytes(v reflect.Value) {
 		v.SetString(string(bytes))
 	case reflect.Slice:
 		if v.Type().Elem().Kind() != reflect.Uint8 {
-			panic(typeMismatch("bytes", v))
+			panic(us.typeError("bytes", "", v))
 		}
 		v.Set(reflect.ValueOf(bytes))
 	default:
-		panic(typeMismatch("bytes", v))
+		panic(us.typeError("bytes", "", v))
 	}
 }
 
-func (us *unmarshalState) readStringUntil(delim byte) string {
-	data, err := us.ReadString(delim)
-	if err != nil {
-		panic(err)
+// rawValue reads the next value without decoding it and returns its
+// encoding exactly as it appeared in the input. When decoding from a byte
+// slice the result aliases it.
+func (us *unmarshalState) rawValue() []byte {
+	if us.r == nil {
+		start := us.off
+		us.skipValue()
+		return us.data[start:us.off]
+	}
+	us.rec, us.recording = nil, true
+	us.skipValue()
+	us.recording = false
+	return us.rec
+}
+
+// skipValue reads the next value, checking that it is well-formed, but
+// does not decode it.
+func (us *unmarshalState) skipValue() {
+	us.countElement()
+	b := us.peekByte()
+	switch {
+	case b == 'l':
+		us.skipByte()
+		us.enter()
+		for us.peekByte() != 'e' {
+			us.skipValue()
+		}
+		us.skipByte()
+		us.depth--
+	case b == 'd':
+		us.skipByte()
+		us.enter()
+		var prev []byte
+		for i := 0; us.peekByte() != 'e'; i++ {
+			if !isDigit(us.peekByte()) {
+				panic(us.syntaxError(fmt.Sprintf("unexpected character %q in dict key", us.peekByte())))
+			}
+			keyOff := us.off
+			us.countElement()
+			key := us.next(us.readLength())
+			us.checkKeyOrder(i, prev, key, keyOff)
+			prev = key
+			us.skipValue()
+		}
+		us.skipByte()
+		us.depth--
+	case b == 'i':
+		us.skipByte()
+		us.readInt()
+	case isDigit(b):
+		us.next(us.readLength())
+	default:
+		panic(us.syntaxError(fmt.Sprintf("unexpected character %q", b)))
+	}
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// readInt reads the body of an integer up to and including the closing 'e'
+// and returns it after checking that it is an optionally signed decimal.
+func (us *unmarshalState) readInt() string {
+	start := us.off
+	data := string(us.readDigits('e', true))
+	if data == "" || data == "-" {
+		panic(&SyntaxError{fmt.Sprintf("invalid integer %q", data), start})
+	}
+	if us.strict && (data == "-0" || hasLeadingZero(strings.TrimPrefix(data, "-"))) {
+		panic(&SyntaxError{fmt.Sprintf("non-canonical integer %q", data), start})
+	}
+	return data
+}
+
+// readLength reads the length prefix of a string up to and including the ':'.
+func (us *unmarshalState) readLength() int {
+	start := us.off
+	digits := us.readDigits(':', false)
+	// lengths are parsed by hand to save allocating a string for each,
+	// 18 digits can't overflow and nothing longer is a plausible length
+	if len(digits) == 0 || len(digits) > 18 {
+		panic(&SyntaxError{fmt.Sprintf("invalid string length %q", digits), start})
+	}
+	if us.strict && hasLeadingZero(string(digits)) {
+		panic(&SyntaxError{fmt.Sprintf("non-canonical string length %q", digits), start})
+	}
+	var length int64
+	for _, d := range digits {
+		length = length*10 + int64(d-'0')
+	}
+	if max := us.limits.MaxStringLength; max > 0 && length > max {
+		panic(&LimitError{"string length", max, start})
+	}
+	return int(length)
+}
+
+func hasLeadingZero(digits string) bool {
+	return len(digits) > 1 && digits[0] == '0'
+}
+
+// readKey reads the i-th key of a dict, prev is the one before it. The
+// result aliases the input or is a new slice, so it stays valid.
+func (us *unmarshalState) readKey(i int, prev []byte) []byte {
+	us.valueOff = us.off
+	if !isDigit(us.peekByte()) {
+		// report the error that decoding anything else into a string does
+		us.unmarshal(reflect.ValueOf(new(string)))
+	}
+	us.countElement()
+	key := us.next(us.readLength())
+	us.checkKeyOrder(i, prev, key, us.valueOff)
+	return key
+}
+
+// checkKeyOrder enforces in strict mode that the i-th key of a dict sorts
+// after the previous one, as raw byte strings.
+func (us *unmarshalState) checkKeyOrder(i int, prev, key []byte, off int64) {
+	if !us.strict || i == 0 {
+		return
+	}
+	c := bytes.Compare(key, prev)
+	if c == 0 {
+		panic(&SyntaxError{fmt.Sprintf("duplicate dict key %q", key), off})
+	}
+	if c < 0 {
+		panic(&SyntaxError{fmt.Sprintf("dict key %q is not sorted after %q", key, prev), off})
+	}
+}
+
+// readDigits reads decimal digits up to and including delim. The digits are
+// checked as they are read so that an error points at the offending byte.
+// The result is only valid until the next call.
+func (us *unmarshalState) readDigits(delim byte, signed bool) []byte {
+	us.scratch = us.scratch[:0]
+	for {
+		b := us.peekByte()
+		if b == delim {
+			us.skipByte()
+			return us.scratch
+		}
+		if !isDigit(b) && !(signed && b == '-' && len(us.scratch) == 0) {
+			panic(us.syntaxError(fmt.Sprintf("unexpected character %q in number", b)))
+		}
+		us.scratch = append(us.scratch, b)
+		us.skipByte()
+	}
+}
+
+// readChunk is the largest string next allocates up front when reading
+// from an io.Reader. Longer ones are read in growing steps so that a length
+// prefix alone cannot make us allocate more memory than there is input.
+const readChunk = 64 << 10
+
+// next consumes the next n bytes of input. When decoding from a byte slice
+// the result aliases it, otherwise it is a new slice.
+func (us *unmarshalState) next(n int) []byte {
+	us.checkInputSize(int64(n))
+	if us.r == nil {
+		if n > len(us.data)-int(us.off) {
+			us.off = int64(len(us.data))
+			panic(io.ErrUnexpectedEOF)
+		}
+		b := us.data[us.off : int(us.off)+n]
+		us.off += int64(n)
+		return b
+	}
+
+	var buf []byte
+	if n <= readChunk {
+		buf = make([]byte, n)
+		read, err := io.ReadFull(us.r, buf)
+		us.off += int64(read)
+		if err != nil {
+			panic(unexpectedEOF(err))
+		}
+	} else {
+		var bb bytes.Buffer
+		read, err := bb.ReadFrom(io.LimitReader(us.r, int64(n)))
+		us.off += read
+		if err != nil {
+			panic(err)
+		}
+		if read < int64(n) {
+			panic(io.ErrUnexpectedEOF)
+		}
+		buf = bb.Bytes()
 	}
-	return data[:len(data)-1]
+	if us.recording {
+		us.rec = append(us.rec, buf...)
+	}
+	return buf
+}
+
+// more returns io.EOF if there is no more input, or the error that
+// prevented reading it.
+func (us *unmarshalState) more() error {
+	if us.r == nil {
+		if int(us.off) >= len(us.data) {
+			return io.EOF
+		}
+		return nil
+	}
+	_, err := us.r.Peek(1)
+	return err
 }
 
 func (us *unmarshalState) peekByte() byte {
-	b, err := us.ReadByte()
-	if err != nil {
-		panic(err)
+	if us.r == nil {
+		if int(us.off) >= len(us.data) {
+			panic(io.ErrUnexpectedEOF)
+		}
+		return us.data[us.off]
 	}
-	err = us.UnreadByte()
+	b, err := us.r.Peek(1)
 	if err != nil {
-		panic(err)
+		panic(unexpectedEOF(err))
 	}
-	return b
+	return b[0]
 }
 
 func (us *unmarshalState) skipByte() {
-	_, err := us.ReadByte()
+	us.checkInputSize(1)
+	if us.r == nil {
+		if int(us.off) >= len(us.data) {
+			panic(io.ErrUnexpectedEOF)
+		}
+		us.off++
+		return
+	}
+	b, err := us.r.ReadByte()
 	if err != nil {
-		panic(err)
+		panic(unexpectedEOF(err))
+	}
+	us.off++
+	if us.recording {
+		us.rec = append(us.rec, b)
+	}
+}
+
+// unexpectedEOF is used for reads in the middle of a value, where running
+// out of input means the value is truncated.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
+	return err
 }
 
-func typeMismatch(bencodeType string, v reflect.Value) error {
-	return fmt.Errorf("cannot unmarshal %s into %v", bencodeType, v.Kind())
+// checkInputSize panics if reading n more bytes would take the current
+// value over the input size limit.
+func (us *unmarshalState) checkInputSize(n int64) {
+	if max := us.limits.MaxInputSize; max > 0 && us.off-us.start+n > max {
+		panic(&LimitError{"input size", max, us.off})
+	}
 }
 
-func structFields(s reflect.Value) map[string]reflect.Value {
-	fields := make(map[string]reflect.Value)
-	for i := 0; i < s.NumField(); i++ {
-		fieldValue := s.Field(i)
-		if !fieldValue.CanSet() {
-			continue
-		}
-		structField := s.Type().Field(i)
-		tag, ok := structField.Tag.Lookup("bencode")
-		if ok && tag == "-" {
-			continue
-		}
-		if ok {
-			fields[tag] = fieldValue
-		}
-		fields[structField.Name] = fieldValue
+func (us *unmarshalState) enter() {
+	us.depth++
+	if max := us.limits.maxDepth(); us.depth > max {
+		panic(&LimitError{"nesting depth", int64(max), us.off - 1})
+	}
+}
+
+func (us *unmarshalState) countElement() {
+	us.elements++
+	if max := us.limits.MaxElements; max > 0 && us.elements > max {
+		panic(&LimitError{"element count", max, us.off})
+	}
+}
+
+func (us *unmarshalState) pushKey(key []byte) {
+	us.path = append(us.path, pathElem{key: key, index: -1})
+}
+
+func (us *unmarshalState) pushIndex(i int) {
+	us.path = append(us.path, pathElem{index: i})
+}
+
+func (us *unmarshalState) popPath() {
+	us.path = us.path[:len(us.path)-1]
+}
+
+func (us *unmarshalState) pathSuffix() string {
+	if len(us.path) == 0 {
+		return ""
+	}
+	return " at " + formatPath(us.path)
+}
+
+func (us *unmarshalState) syntaxError(msg string) error {
+	return &SyntaxError{msg, us.off}
+}
+
+func (us *unmarshalState) typeError(bencodeType, literal string, v reflect.Value) error {
+	return &UnmarshalTypeError{
+		Value:   bencodeType,
+		Literal: literal,
+		Type:    v.Type(),
+		Offset:  us.valueOff,
+		Field:   formatPath(us.path),
 	}
-	return fields
 }
 
-func indirect(v reflect.Value) reflect.Value {
+// indirect walks down v allocating pointers as needed until it gets to
+// a non-pointer or to a value implementing Unmarshaler.
+func indirect(v reflect.Value) (Unmarshaler, reflect.Value) {
 	if v.Kind() != reflect.Ptr && v.Type().Name() != "" && v.CanAddr() {
 		v = v.Addr()
 	}
@@ -282,7 +712,33 @@ func indirect(v reflect.Value) reflect.Value {
 		if v.IsNil() {
 			v.Set(reflect.New(v.Type().Elem()))
 		}
+		if u, ok := v.Interface().(Unmarshaler); ok {
+			return u, reflect.Value{}
+		}
 		v = v.Elem()
 	}
-	return v
+	return nil, v
+}
+
+// Valid reports whether data is exactly one well-formed bencode value.
+func Valid(data []byte) bool {
+	return checkValid(data, false) == nil
+}
+
+// IsCanonical reports whether data is exactly one bencode value in the
+// canonical form, the only one Marshal produces. Use a Decoder with
+// DisallowNonCanonical to find out what is wrong with non-canonical input.
+func IsCanonical(data []byte) bool {
+	return checkValid(data, true) == nil
+}
+
+// checkValid reports an error unless data is exactly one bencode value.
+func checkValid(data []byte, strict bool) (err error) {
+	defer handlePanic(&err)
+	us := unmarshalState{data: data, limits: DefaultLimits, strict: strict}
+	us.skipValue()
+	if us.off != int64(len(data)) {
+		return errors.New("trailing data after top-level value")
+	}
+	return
 }
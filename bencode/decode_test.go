@@ -1,7 +1,10 @@
 package bencode
 
 import (
+	"errors"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -42,7 +45,7 @@ func TestUnmarshal(t *testing.T) {
 			t.Fatalf("Error while unmarshalling %v: %v", tt.in, err)
 		}
 		if !reflect.DeepEqual(res, tt.out) {
-			t.Fatalf("Unmarshal %v err: wanted %v(%t) got %v(%t)",
+			t.Fatalf("Unmarshal %v err: wanted %v(%T) got %v(%T)",
 				tt.in, tt.out, tt.out, res, res)
 		}
 	}
@@ -58,7 +61,7 @@ func TestUnmarshalList2Slice(t *testing.T) {
 		t.Fatalf("Error while unmarshalling %v: %v", in, err)
 	}
 	if !reflect.DeepEqual(res, out) {
-		t.Fatalf("Unmarshal %v err: wanted %v(%t) got %v(%t)",
+		t.Fatalf("Unmarshal %v err: wanted %v(%T) got %v(%T)",
 			in, out, out, res, res)
 	}
 }
@@ -73,7 +76,7 @@ func TestUnmarshalList2Array(t *testing.T) {
 		t.Fatalf("Error while unmarshalling %v: %v", in, err)
 	}
 	if !reflect.DeepEqual(res, out) {
-		t.Fatalf("Unmarshal %v err: wanted %v(%t) got %v(%t)",
+		t.Fatalf("Unmarshal %v err: wanted %v(%T) got %v(%T)",
 			in, out, out, res, res)
 	}
 }
@@ -106,7 +109,7 @@ func TestUnmarshalDict2Struct(t *testing.T) {
 			t.Fatalf("Error while unmarshalling %v: %v", tt.in, err)
 		}
 		if !reflect.DeepEqual(res, tt.out) {
-			t.Fatalf("Unmarshal %v err: wanted %v(%t) got %v(%t)",
+			t.Fatalf("Unmarshal %v err: wanted %v(%T) got %v(%T)",
 				tt.in, tt.out, tt.out, res, res)
 		}
 	}
@@ -136,7 +139,7 @@ func TestUnmarshalDict2Struct(t *testing.T) {
 			t.Fatalf("Error while unmarshalling %v: %v", tt.in, err)
 		}
 		if !reflect.DeepEqual(res, tt.out) {
-			t.Fatalf("Unmarshal %v err: wanted %v(%t) got %v(%t)",
+			t.Fatalf("Unmarshal %v err: wanted %v(%T) got %v(%T)",
 				tt.in, tt.out, tt.out, res, res)
 		}
 	}
@@ -152,6 +155,9 @@ func TestUnmarshalInvalid(t *testing.T) {
 	err := Unmarshal([]byte("i1e"), i)
 	assertErrContains(t, err, "cannot unmarshal into non-ptr")
 
+	err = Unmarshal([]byte("i1e"), (*int)(nil))
+	assertErrContains(t, err, "cannot unmarshal into nil *int")
+
 	err = Unmarshal([]byte("3:foo"), &i)
 	assertErrContains(t, err, "cannot unmarshal bytes into int")
 
@@ -165,10 +171,18 @@ func TestUnmarshalInvalid(t *testing.T) {
 	assertErrContains(t, err, "cannot unmarshal list into map")
 
 	err = Unmarshal([]byte("d1:a1:be"), &mInt)
-	assertErrContains(t, err, "map keys must be of type string")
+	assertErrContains(t, err, `cannot unmarshal bytes "a" into int at a (offset 1)`)
+
+	var mFloat map[float64]int
+	err = Unmarshal([]byte("d1:1i1ee"), &mFloat)
+	assertErrContains(t, err, "cannot unmarshal dict into map[float64]int")
+
+	var mPoint map[testPoint]int
+	err = Unmarshal([]byte("d1:1i1ee"), &mPoint)
+	assertErrContains(t, err, `cannot unmarshal dict key "1" into bencode.testPoint`)
 
 	err = Unmarshal([]byte("3foo"), &dummyRes)
-	assertErrContains(t, err, "EOF")
+	assertErrContains(t, err, "unexpected character 'f'")
 
 	err = Unmarshal([]byte("li1e"), &dummyRes)
 	assertErrContains(t, err, "EOF")
@@ -177,3 +191,526 @@ func TestUnmarshalInvalid(t *testing.T) {
 	assertErrContains(t, err, "cannot unmarshal integer into string")
 
 }
+
+func TestUnmarshalMapKeys(t *testing.T) {
+	type extensionName string
+	var testCases = []struct {
+		in  string
+		out interface{}
+	}{
+		{"d11:ut_metadatai3e6:ut_pexi1ee", map[extensionName]uint8{"ut_metadata": 3, "ut_pex": 1}},
+		{"d2:-1i1e1:7i2ee", map[int8]int{-1: 1, 7: 2}},
+		{"d20:18446744073709551615i1ee", map[uint64]int{18446744073709551615: 1}},
+		{"d3:1,2i3ee", map[testPoint]int{{1, 2}: 3}},
+	}
+	for _, tc := range testCases {
+		res := reflect.New(reflect.TypeOf(tc.out))
+		if err := Unmarshal([]byte(tc.in), res.Interface()); err != nil {
+			t.Fatalf("Error while unmarshalling %v: %v", tc.in, err)
+		}
+		if !reflect.DeepEqual(res.Elem().Interface(), tc.out) {
+			t.Fatalf("Unmarshal %v err: wanted %v got %v", tc.in, tc.out, res.Elem())
+		}
+	}
+
+	var m map[int8]int
+	err := Unmarshal([]byte("d3:300i1ee"), &m)
+	assertErrContains(t, err, `cannot unmarshal bytes "300" into int8 at 300`)
+}
+
+func TestDecoder(t *testing.T) {
+	in := "i42e3:fooli1ei2eede"
+	var testCases = []struct {
+		out    interface{}
+		offset int64
+	}{
+		{int64(42), 4},
+		{[]byte("foo"), 9},
+		{[]interface{}{int64(1), int64(2)}, 17},
+		{map[string]interface{}{}, 19},
+	}
+
+	dec := NewDecoder(strings.NewReader(in))
+	for _, tt := range testCases {
+		var res interface{}
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("Error while decoding %v: %v", in, err)
+		}
+		if !reflect.DeepEqual(res, tt.out) {
+			t.Fatalf("Decode %v err: wanted %v(%T) got %v(%T)",
+				in, tt.out, tt.out, res, res)
+		}
+		if dec.InputOffset() != tt.offset {
+			t.Fatalf("Decode %v err: wanted offset %v got %v",
+				in, tt.offset, dec.InputOffset())
+		}
+	}
+
+	var res interface{}
+	if err := dec.Decode(&res); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1eli2e"))
+	var res interface{}
+	if err := dec.Decode(&res); err != nil {
+		t.Fatalf("Error while decoding: %v", err)
+	}
+	if err := dec.Decode(&res); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1erest"))
+	var res int
+	if err := dec.Decode(&res); err != nil {
+		t.Fatalf("Error while decoding: %v", err)
+	}
+	rest, err := io.ReadAll(dec.Buffered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "rest" {
+		t.Fatalf("expected buffered \"rest\", got %q", rest)
+	}
+}
+
+func TestUnmarshalRawMessage(t *testing.T) {
+	type TestStruct struct {
+		Info RawMessage `bencode:"info"`
+		Name string     `bencode:"name"`
+	}
+
+	// keys are unsorted and the integer is not canonical, the raw bytes
+	// must still be preserved exactly
+	in := "d4:infod1:bi03e1:ali1e2:xyee4:name3:fooe"
+	var res TestStruct
+	err := Unmarshal([]byte(in), &res)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling %v: %v", in, err)
+	}
+	if string(res.Info) != "d1:bi03e1:ali1e2:xyee" {
+		t.Fatalf("Unmarshal %v err: wanted raw info %v got %v",
+			in, "d1:bi03e1:ali1e2:xyee", string(res.Info))
+	}
+	if res.Name != "foo" {
+		t.Fatalf("Unmarshal %v err: wanted name foo got %v", in, res.Name)
+	}
+
+	var raw RawMessage
+	err = Unmarshal([]byte("l03:abci-0ee"), &raw)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling: %v", err)
+	}
+	if string(raw) != "l03:abci-0ee" {
+		t.Fatalf("Unmarshal err: wanted l03:abci-0ee got %v", string(raw))
+	}
+
+	err = Unmarshal([]byte("d1:ai1eei2e"), &raw)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling: %v", err)
+	}
+	if string(raw) != "d1:ai1ee" {
+		t.Fatalf("Unmarshal err: wanted d1:ai1ee got %v", string(raw))
+	}
+
+	err = Unmarshal([]byte("di1ei2ee"), &raw)
+	assertErrContains(t, err, "dict key")
+	err = Unmarshal([]byte("ixe"), &raw)
+	assertErrContains(t, err, "unexpected character 'x'")
+	err = Unmarshal([]byte("l3:abe"), &raw)
+	assertErrContains(t, err, "EOF")
+}
+
+type testCSV []string
+
+func (c testCSV) MarshalBencode() ([]byte, error) {
+	return Marshal(strings.Join(c, ","))
+}
+
+func (c *testCSV) UnmarshalBencode(data []byte) error {
+	var s string
+	if err := Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*c = strings.Split(s, ",")
+	return nil
+}
+
+type testBadMarshaler struct{}
+
+func (testBadMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i1e2"), nil
+}
+
+func (*testBadMarshaler) UnmarshalBencode(data []byte) error {
+	return errors.New("bad unmarshaler")
+}
+
+type testPanicker []int
+
+func (p *testPanicker) UnmarshalBencode(data []byte) error {
+	(*p)[len(data)] = 1
+	return nil
+}
+
+func TestUnmarshalRuntimePanic(t *testing.T) {
+	var p testPanicker
+	err := Unmarshal([]byte("i1e"), &p)
+	assertErrContains(t, err, "internal error")
+}
+
+func TestUnmarshalUnmarshaler(t *testing.T) {
+	type TestStruct struct {
+		Tags   testCSV    `bencode:"tags"`
+		TagPtr *testCSV   `bencode:"ptr"`
+		Lists  []testCSV  `bencode:"lists"`
+		Raw    RawMessage `bencode:"raw"`
+	}
+
+	in := "d5:listsl3:a,b1:ce3:ptr3:x,y3:rawli1ee4:tags5:1,2,3e"
+	out := TestStruct{
+		Tags:   testCSV{"1", "2", "3"},
+		TagPtr: &testCSV{"x", "y"},
+		Lists:  []testCSV{{"a", "b"}, {"c"}},
+		Raw:    RawMessage("li1ee"),
+	}
+	var res TestStruct
+	err := Unmarshal([]byte(in), &res)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling %v: %v", in, err)
+	}
+	if !reflect.DeepEqual(res, out) {
+		t.Fatalf("Unmarshal %v err: wanted %v got %v", in, out, res)
+	}
+
+	var bad struct {
+		Bad testBadMarshaler
+	}
+	err = Unmarshal([]byte("d3:Badi1ee"), &bad)
+	assertErrContains(t, err, "bad unmarshaler")
+}
+
+func TestUnmarshalTagOptions(t *testing.T) {
+	type TestStruct struct {
+		Name    string `bencode:"name,required"`
+		Comment string `bencode:"comment,omitempty"`
+		Port    int    `bencode:"port,string"`
+		Size    uint16 `bencode:",string"`
+	}
+
+	testCases := []struct {
+		in  string
+		out TestStruct
+	}{
+		{"d4:name3:fooe", TestStruct{Name: "foo"}},
+		{"d7:comment2:hi4:name3:fooe", TestStruct{Name: "foo", Comment: "hi"}},
+		{"d4:Sizei7e4:name3:foo4:port4:6881e", TestStruct{Name: "foo", Port: 6881, Size: 7}},
+		{"d4:Size1:74:name3:foo4:porti6881ee", TestStruct{Name: "foo", Port: 6881, Size: 7}},
+	}
+	for _, tt := range testCases {
+		var res TestStruct
+		err := Unmarshal([]byte(tt.in), &res)
+		if err != nil {
+			t.Fatalf("Error while unmarshalling %v: %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(res, tt.out) {
+			t.Fatalf("Unmarshal %v err: wanted %v got %v", tt.in, tt.out, res)
+		}
+	}
+
+	var res TestStruct
+	err := Unmarshal([]byte("d7:comment2:hie"), &res)
+	assertErrContains(t, err, `missing required key "name"`)
+
+	err = Unmarshal([]byte("d4:name3:foo4:port3:abce"), &res)
+	assertErrContains(t, err, `cannot unmarshal bytes "abc" into int at port`)
+
+	type PtrStruct struct {
+		Port *int `bencode:"port,string"`
+	}
+	var ptr PtrStruct
+	if err := Unmarshal([]byte("d4:port4:6881e"), &ptr); err != nil || ptr.Port == nil || *ptr.Port != 6881 {
+		t.Fatalf("Unmarshal into *int with string option: got %v, %v", ptr.Port, err)
+	}
+
+	type BadStruct struct {
+		Name string `bencode:"name,string"`
+	}
+	var bad BadStruct
+	err = Unmarshal([]byte("d4:name1:ae"), &bad)
+	assertErrContains(t, err, "string option on field Name of type string, which is not an integer")
+
+	err = Unmarshal([]byte("d4:Sizei70000e4:name3:fooe"), &res)
+	assertErrContains(t, err, "cannot unmarshal integer 70000 into uint16 at Size")
+}
+
+func TestDecoderDisallowUnknownFields(t *testing.T) {
+	type TestStruct struct {
+		Foo string `bencode:"foo"`
+	}
+
+	var res TestStruct
+	err := Unmarshal([]byte("d3:bari1e3:foo1:ae"), &res)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling: %v", err)
+	}
+
+	dec := NewDecoder(strings.NewReader("d3:bari1e3:foo1:ae"))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&res)
+	assertErrContains(t, err, `unknown key "bar"`)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type File struct {
+		Length int      `bencode:"length"`
+		Path   []string `bencode:"path"`
+	}
+	type Info struct {
+		Files []File `bencode:"files"`
+	}
+	type MetaInfo struct {
+		Info Info `bencode:"info"`
+	}
+
+	in := "d4:infod5:filesld6:lengthi1eed6:lengthi2e4:pathl1:aeed6:length3:badeeee"
+	var res MetaInfo
+	err := Unmarshal([]byte(in), &res)
+	var typeErr *UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected *UnmarshalTypeError, got %#v", err)
+	}
+	if typeErr.Value != "bytes" || typeErr.Type != reflect.TypeOf(0) {
+		t.Fatalf("unexpected type error %#v", typeErr)
+	}
+	if typeErr.Field != "info.files[2].length" {
+		t.Fatalf("expected field info.files[2].length, got %v", typeErr.Field)
+	}
+	if typeErr.Offset != 62 {
+		t.Fatalf("expected offset 62, got %v", typeErr.Offset)
+	}
+
+	var small int8
+	err = Unmarshal([]byte("i300e"), &small)
+	if !errors.As(err, &typeErr) || typeErr.Value != "integer" || typeErr.Literal != "300" {
+		t.Fatalf("unexpected type error %#v", err)
+	}
+
+	var syntaxCases = []struct {
+		in     string
+		offset int64
+	}{
+		{"x", 0},
+		{"li1ex", 4},
+		{"i1-2e", 2},
+		{"ie", 1},
+		{"i-e", 1},
+		{"i+1e", 1},
+		{"d1:ai1e1x", 8},
+		{"-1:a", 0},
+		{"1a:x", 1},
+	}
+	for _, tt := range syntaxCases {
+		var res interface{}
+		err := Unmarshal([]byte(tt.in), &res)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("Unmarshal %v: expected *SyntaxError, got %#v", tt.in, err)
+		}
+		if syntaxErr.Offset != tt.offset {
+			t.Fatalf("Unmarshal %v: expected offset %v, got %v (%v)",
+				tt.in, tt.offset, syntaxErr.Offset, err)
+		}
+	}
+
+	for _, in := range []string{"", "i12", "4:abc", "l", "d1:a", "li1e"} {
+		var res interface{}
+		err := Unmarshal([]byte(in), &res)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Unmarshal %q: expected io.ErrUnexpectedEOF, got %v", in, err)
+		}
+	}
+}
+
+func TestDecoderDisallowNonCanonical(t *testing.T) {
+	var testCases = []struct {
+		in          string
+		errContains string
+	}{
+		{"i03e", `non-canonical integer "03" at offset 1`},
+		{"i-0e", `non-canonical integer "-0" at offset 1`},
+		{"i-01e", `non-canonical integer "-01" at offset 1`},
+		{"03:abc", `non-canonical string length "03" at offset 0`},
+		{"d1:bi1e1:ai2ee", `dict key "a" is not sorted after "b" at offset 7`},
+		{"d1:ai1e1:ai2ee", `duplicate dict key "a" at offset 7`},
+		{"ld2:abi1e2:aai2eee", `dict key "aa" is not sorted after "ab" at offset 9`},
+	}
+	for _, tt := range testCases {
+		var res interface{}
+		err := Unmarshal([]byte(tt.in), &res)
+		if err != nil {
+			t.Fatalf("Error while unmarshalling %v: %v", tt.in, err)
+		}
+
+		dec := NewDecoder(strings.NewReader(tt.in))
+		dec.DisallowNonCanonical()
+		err = dec.Decode(&res)
+		assertErrContains(t, err, tt.errContains)
+
+		var raw RawMessage
+		dec = NewDecoder(strings.NewReader(tt.in))
+		dec.DisallowNonCanonical()
+		err = dec.Decode(&raw)
+		assertErrContains(t, err, tt.errContains)
+
+		if !Valid([]byte(tt.in)) {
+			t.Fatalf("Valid(%v) = false, want true", tt.in)
+		}
+		if IsCanonical([]byte(tt.in)) {
+			t.Fatalf("IsCanonical(%v) = true, want false", tt.in)
+		}
+	}
+
+	type TestStruct struct {
+		A int `bencode:"a"`
+		B int `bencode:"b"`
+	}
+	dec := NewDecoder(strings.NewReader("d1:bi1e1:ai2ee"))
+	dec.DisallowNonCanonical()
+	var res TestStruct
+	err := dec.Decode(&res)
+	assertErrContains(t, err, `dict key "a" is not sorted after "b"`)
+}
+
+func TestValid(t *testing.T) {
+	var testCases = []struct {
+		in        string
+		valid     bool
+		canonical bool
+	}{
+		{"i0e", true, true},
+		{"i-1e", true, true},
+		{"0:", true, true},
+		{"le", true, true},
+		{"d1:a0:1:bi1ee", true, true},
+		{"d0:i1e1:ai2ee", true, true},
+		{"i01e", true, false},
+		{"", false, false},
+		{"i1ei2e", false, false},
+		{"i1", false, false},
+		{"di1ei2ee", false, false},
+		{"5:abc", false, false},
+	}
+	for _, tt := range testCases {
+		if Valid([]byte(tt.in)) != tt.valid {
+			t.Fatalf("Valid(%q) = %v, want %v", tt.in, !tt.valid, tt.valid)
+		}
+		if IsCanonical([]byte(tt.in)) != tt.canonical {
+			t.Fatalf("IsCanonical(%q) = %v, want %v", tt.in, !tt.canonical, tt.canonical)
+		}
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	var testCases = []struct {
+		in     string
+		limits Limits
+		limit  string
+		offset int64
+	}{
+		{"99999999999:", Limits{MaxStringLength: 1 << 20}, "string length", 0},
+		{"l3:abc4:abcde", Limits{MaxStringLength: 3}, "string length", 6},
+		{"lllleeee", Limits{MaxDepth: 3}, "nesting depth", 3},
+		{"d1:ad1:ad1:alee", Limits{MaxDepth: 3}, "nesting depth", 12},
+		{"li1ei2ei3ee", Limits{MaxElements: 3}, "element count", 7},
+		{"d1:ai1e1:bi2ee", Limits{MaxElements: 4}, "element count", 10},
+		{"li1ei2ei3ee", Limits{MaxInputSize: 8}, "input size", 8},
+		{"l10:abcdefghije", Limits{MaxInputSize: 8}, "input size", 4},
+	}
+	for _, tt := range testCases {
+		for _, raw := range []bool{false, true} {
+			dec := NewDecoder(strings.NewReader(tt.in))
+			dec.SetLimits(tt.limits)
+			var err error
+			if raw {
+				var res RawMessage
+				err = dec.Decode(&res)
+			} else {
+				var res interface{}
+				err = dec.Decode(&res)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("Decode %v: expected *LimitError, got %v", tt.in, err)
+			}
+			if limitErr.Limit != tt.limit || limitErr.Offset != tt.offset {
+				t.Fatalf("Decode %v: expected %v limit at offset %v, got %v",
+					tt.in, tt.limit, tt.offset, err)
+			}
+		}
+	}
+
+	// limits apply to each value separately
+	dec := NewDecoder(strings.NewReader("li1ei2eeli3ei4ee"))
+	dec.SetLimits(Limits{MaxElements: 3, MaxInputSize: 8})
+	for i := 0; i < 2; i++ {
+		var res []int
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("Error while decoding value %d: %v", i, err)
+		}
+	}
+}
+
+func TestUnmarshalDefaultLimits(t *testing.T) {
+	deep := strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1)
+	var res interface{}
+	err := Unmarshal([]byte(deep), &res)
+	assertErrContains(t, err, "nesting depth exceeds limit")
+
+	// a huge length must not be allocated before the input runs out
+	err = Unmarshal([]byte("99999999999:abc"), &res)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestUnmarshalEmbeddedPointersBools(t *testing.T) {
+	in := "d5:extra1:x4:flagi1e2:idi7e4:name5:outere"
+	out := testEmbedded{
+		testBase:  testBase{ID: 7},
+		TestOther: &TestOther{Extra: "x"},
+		Name:      "outer",
+		Flag:      true,
+	}
+	var res testEmbedded
+	err := Unmarshal([]byte(in), &res)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling %v: %v", in, err)
+	}
+	if !reflect.DeepEqual(res, out) {
+		t.Fatalf("Unmarshal %v err: wanted %+v got %+v", in, out, res)
+	}
+
+	type TestStruct struct {
+		Ptr  *int  `bencode:"ptr"`
+		Flag *bool `bencode:"flag"`
+	}
+	var ptrs TestStruct
+	err = Unmarshal([]byte("d4:flagi0e3:ptri5ee"), &ptrs)
+	if err != nil {
+		t.Fatalf("Error while unmarshalling: %v", err)
+	}
+	if ptrs.Ptr == nil || *ptrs.Ptr != 5 || ptrs.Flag == nil || *ptrs.Flag {
+		t.Fatalf("Unmarshal err: got %+v", ptrs)
+	}
+
+	var b bool
+	err = Unmarshal([]byte("i2e"), &b)
+	assertErrContains(t, err, "cannot unmarshal integer 2 into bool")
+	err = Unmarshal([]byte("1:1"), &b)
+	assertErrContains(t, err, "cannot unmarshal bytes into bool")
+}
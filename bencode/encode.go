@@ -4,26 +4,79 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 )
 
+// Marshaler is the interface implemented by types that can marshal
+// themselves into valid bencode.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
 func Marshal(data interface{}) (result []byte, err error) {
 	defer handlePanic(&err)
 	ms := new(marshalState)
-	ms.marshal(reflect.ValueOf(data))
+	ms.marshal(addressable(data))
 	result = ms.Bytes()
 	return
 }
 
+// An Encoder writes bencode values to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v to the stream. Values are written back to
+// back with no separator, which is how a Decoder expects to read them.
+func (e *Encoder) Encode(v interface{}) (err error) {
+	defer handlePanic(&err)
+	ms := new(marshalState)
+	ms.marshal(addressable(v))
+	_, err = ms.WriteTo(e.w)
+	return
+}
+
+// addressable returns the reflect.Value of a copy of v that can be addressed,
+// so that MarshalBencode methods with pointer receivers are used for v and
+// the fields inside it whether or not v is passed by pointer.
+func addressable(v interface{}) reflect.Value {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr {
+		return rv
+	}
+	p := reflect.New(rv.Type()).Elem()
+	p.Set(rv)
+	return p
+}
+
 type marshalState struct {
 	bytes.Buffer
 	scratch [64]byte
 }
 
 func (ms *marshalState) marshal(data reflect.Value) {
+	if !data.IsValid() {
+		panic(errors.New("cannot marshal nil"))
+	}
+	if data.Kind() != reflect.Ptr && data.CanAddr() &&
+		reflect.PtrTo(data.Type()).Implements(marshalerType) {
+		data = data.Addr()
+	}
+	if data.Type().Implements(marshalerType) && !isNilPtr(data) {
+		ms.marshalMarshaler(data.Interface().(Marshaler))
+		return
+	}
+
 	switch data.Kind() {
 	case reflect.String:
 		ms.marshalBytes([]byte(data.String()))
@@ -31,6 +84,12 @@ func (ms *marshalState) marshal(data reflect.Value) {
 		ms.marshalInt64(data.Int())
 	case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
 		ms.marshalUint64(data.Uint())
+	case reflect.Bool:
+		if data.Bool() {
+			ms.marshalInt64(1)
+		} else {
+			ms.marshalInt64(0)
+		}
 	case reflect.Slice:
 		if data.Type().Elem().Kind() == reflect.Uint8 {
 			ms.marshalBytes(data.Bytes())
@@ -40,14 +99,18 @@ func (ms *marshalState) marshal(data reflect.Value) {
 	case reflect.Array:
 		ms.marshalList(data)
 	case reflect.Map:
-		keyKind := data.Type().Key().Kind()
-		if keyKind != reflect.String {
-			panic(fmt.Errorf("cannot unmarshal map with key type %t", data.Interface()))
+		if kt := data.Type().Key(); !canMarshalKey(kt) {
+			panic(fmt.Errorf("cannot marshal map with key type %v", kt))
 		}
 		ms.marshalMap(data)
 	case reflect.Struct:
 		ms.marshalStruct(data)
-	case reflect.Interface:
+	case reflect.Ptr, reflect.Interface:
+		// nil values are left out of dicts, elsewhere there is no way
+		// to represent them
+		if data.IsNil() {
+			panic(fmt.Errorf("cannot marshal nil %v", data.Type()))
+		}
 		ms.marshal(data.Elem())
 	default:
 		panic(fmt.Errorf("err: %v has unsupported type %v",
@@ -55,6 +118,21 @@ func (ms *marshalState) marshal(data reflect.Value) {
 	}
 }
 
+func (ms *marshalState) marshalMarshaler(m Marshaler) {
+	b, err := m.MarshalBencode()
+	if err != nil {
+		panic(err)
+	}
+	if err := checkValid(b, false); err != nil {
+		panic(fmt.Errorf("MarshalBencode returned invalid bencode: %v", err))
+	}
+	ms.Write(b)
+}
+
+func isNilPtr(v reflect.Value) bool {
+	return (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()
+}
+
 func (ms *marshalState) marshalBytes(bs []byte) {
 	b := strconv.AppendInt(ms.scratch[:0], int64(len(bs)), 10)
 	ms.Write(b)
@@ -87,64 +165,72 @@ func (ms *marshalState) marshalList(l reflect.Value) {
 func (ms *marshalState) marshalMap(m reflect.Value) {
 	ms.WriteByte('d')
 	// bencoding specification states that the keys must be sorted
-	keys := m.MapKeys()
+	type entry struct {
+		key []byte
+		val reflect.Value
+	}
+	var entries []entry
+	for iter := m.MapRange(); iter.Next(); {
+		if !isNilPtr(iter.Value()) {
+			entries = append(entries, entry{marshalKey(iter.Key()), iter.Value()})
+		}
+	}
 	sort.Slice(
-		keys,
-		func(i, j int) bool { return keys[i].String() < keys[j].String() },
+		entries,
+		func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 },
 	)
-	for _, k := range keys {
-		ms.marshalBytes([]byte(k.String()))
-		ms.marshal(m.MapIndex(k))
+	for _, e := range entries {
+		ms.marshalBytes(e.key)
+		ms.marshal(e.val)
 	}
 	ms.WriteByte('e')
 }
 
 func (ms *marshalState) marshalStruct(s reflect.Value) {
 	ms.WriteByte('d')
-	fields := getExportedFields(s)
-	sort.Slice(
-		fields,
-		func(i, j int) bool { return bencodeName(fields[i]) < bencodeName(fields[j]) },
-	)
-	for _, f := range fields {
-		ms.marshalBytes([]byte(bencodeName(f)))
-		ms.marshal(s.FieldByName(f.Name))
-	}
-	ms.WriteByte('e')
-}
-
-func getExportedFields(s reflect.Value) (fields []reflect.StructField) {
-	for i := 0; i < s.NumField(); i++ {
-		field := s.Type().Field(i)
-		if field.Anonymous || field.PkgPath != "" {
+	for _, f := range cachedTypeFields(s.Type()).list {
+		fv, ok := fieldByIndex(s, f.index)
+		if !ok || isNilPtr(fv) || f.omitEmpty && isEmptyValue(fv) {
 			continue
 		}
-		if bencodeName(field) == "-" {
-			continue
+		ms.marshalBytes([]byte(f.name))
+		if f.asString {
+			ms.marshalIntString(fv)
+		} else {
+			ms.marshal(fv)
 		}
-		fields = append(fields, field)
 	}
-	return fields
+	ms.WriteByte('e')
 }
 
-func bencodeName(field reflect.StructField) string {
-	if tag, ok := field.Tag.Lookup("bencode"); ok {
-		return tag
+func (ms *marshalState) marshalIntString(v reflect.Value) {
+	// marshalBytes uses the scratch space for the length prefix
+	var buf [20]byte
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		ms.marshalBytes(strconv.AppendInt(buf[:0], v.Int(), 10))
+	default:
+		ms.marshalBytes(strconv.AppendUint(buf[:0], v.Uint(), 10))
 	}
-	return field.Name
 }
 
+// handlePanic turns a panic in the encoder or decoder into an error. The
+// package panics with errors and strings to bail out, anything else is a
+// bug, but bencode often comes from untrusted peers and a bug must not let
+// them crash the program.
 func handlePanic(err *error) {
-	if r := recover(); r != nil {
-		if _, ok := r.(runtime.Error); ok {
-			panic(r)
-		} else if _, ok := r.(error); ok {
-			*err = r.(error)
-		} else if _, ok := r.(string); ok {
-			*err = errors.New(r.(string))
-		} else {
-			panic(r)
-		}
-
+	switch r := recover().(type) {
+	case nil:
+	case runtime.Error:
+		*err = fmt.Errorf("internal error: %v", r)
+	case error:
+		*err = r
+	case string:
+		*err = errors.New(r)
+	default:
+		*err = fmt.Errorf("internal error: %v", r)
 	}
 }
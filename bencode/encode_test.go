@@ -2,6 +2,8 @@ package bencode
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -20,6 +22,13 @@ func TestMarshal(t *testing.T) {
 		Pizza       string `bencode:"pizza"`
 		NotIncluded string `bencode:"-"`
 	}
+	type TestStruct4 struct {
+		Name    string   `bencode:"name,required"`
+		Comment string   `bencode:"comment,omitempty"`
+		List    []string `bencode:"list,omitempty"`
+		Port    int      `bencode:"port,string"`
+		Renamed int      `bencode:",omitempty"`
+	}
 	var testCases = []struct {
 		in  interface{}
 		out string
@@ -59,6 +68,32 @@ func TestMarshal(t *testing.T) {
 			"d2:Hi5:Hello2:Tsd3:Bari1e3:Foo3:benee",
 		},
 		{TestStruct3{Pizza: "cool"}, "d5:pizza4:coole"},
+
+		{TestStruct4{Name: "foo", Port: 6881}, "d4:name3:foo4:port4:6881e"},
+		{
+			TestStruct4{Name: "foo", Comment: "hi", List: []string{"a"}, Renamed: 1},
+			"d7:Renamedi1e7:comment2:hi4:listl1:ae4:name3:foo4:port1:0e",
+		},
+
+		{RawMessage("d1:bi03e1:ai1ee"), "d1:bi03e1:ai1ee"},
+		{[]RawMessage{RawMessage("i1e"), RawMessage("0:")}, "li1e0:e"},
+
+		{testCSV{"a", "b"}, "3:a,b"},
+		{[]testCSV{{"a"}, {"b", "c"}}, "l1:a3:b,ce"},
+		{map[string]testCSV{"x": {"1", "2"}}, "d1:x3:1,2e"},
+		{map[testKey]int{"b": 1, "a": 2}, "d1:ai2e1:bi1ee"},
+		{map[int]int{10: 1, 9: 2, -1: 3}, "d2:-1i3e2:10i1e1:9i2ee"},
+		{map[uint8]string{255: "x"}, "d3:2551:xe"},
+		{map[testPoint]int{{1, 2}: 3, {0, 5}: 4}, "d3:0,5i4e3:1,2i3ee"},
+		// slice elements are addressable, so pointer receivers apply
+		{[]testPtrMarshaler{{1}, {2}}, "li2ei4ee"},
+		{struct {
+			Port *int `bencode:"port,string"`
+		}{&testPort}, "d4:port4:6881e"},
+		// and so are values passed by value, and their fields
+		{testPtrMarshaler{3}, "i6e"},
+		{testPtrOuter{P: testPtrMarshaler{4}}, "d1:pi8ee"},
+		{&testPtrOuter{P: testPtrMarshaler{4}}, "d1:pi8ee"},
 	}
 
 	for _, tc := range testCases {
@@ -78,7 +113,12 @@ func TestMarshalInvalid(t *testing.T) {
 		errContains string
 	}{
 		{[]chan int{make(chan int)}, "unsupported type"},
-		{map[int]int{1: 1}, "cannot unmarshal map"},
+		{map[float64]int{1: 1}, "cannot marshal map with key type float64"},
+		{map[float64]int{}, "cannot marshal map with key type float64"},
+		{map[testPoint]int{{-1, 0}: 1}, "cannot marshal map key"},
+		{RawMessage{}, "empty RawMessage"},
+		{RawMessage("i1ei2e"), "invalid bencode"},
+		{testBadMarshaler{}, "invalid bencode"},
 	}
 	for _, tc := range testCases {
 		_, err := Marshal(tc.in)
@@ -87,6 +127,55 @@ func TestMarshalInvalid(t *testing.T) {
 
 }
 
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []interface{}{42, "foo", []int{1, 2}, map[string]int{}, testPtrOuter{P: testPtrMarshaler{4}}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Error while encoding %v: %v", v, err)
+		}
+	}
+	if buf.String() != "i42e3:fooli1ei2eeded1:pi8ee" {
+		t.Fatalf("Encode err: got %v", buf.String())
+	}
+
+	err := enc.Encode(make(chan int))
+	assertErrContains(t, err, "unsupported type")
+}
+
+type testPtrMarshaler struct {
+	N int
+}
+
+func (m *testPtrMarshaler) MarshalBencode() ([]byte, error) {
+	return Marshal(m.N * 2)
+}
+
+var testPort = 6881
+
+type testPtrOuter struct {
+	P testPtrMarshaler `bencode:"p"`
+}
+
+type testKey string
+
+// testPoint is encoded as "x,y" when it is a map key.
+type testPoint struct {
+	X, Y int
+}
+
+func (p testPoint) MarshalText() ([]byte, error) {
+	if p.X < 0 || p.Y < 0 {
+		return nil, errors.New("negative coordinate")
+	}
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *testPoint) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
 func assertErrContains(t *testing.T, err error, contains string) {
 	if err == nil {
 		t.Fatal("expected error, got nil")
@@ -95,3 +184,80 @@ func assertErrContains(t *testing.T, err error, contains string) {
 		t.Fatalf("expected error containing \"%s\", got \"%s\" instead", contains, err.Error())
 	}
 }
+
+type testBase struct {
+	ID   int    `bencode:"id"`
+	Name string `bencode:"name"`
+}
+
+type TestOther struct {
+	Name  string `bencode:"name"`
+	Extra string `bencode:"extra"`
+}
+
+type testEmbedded struct {
+	testBase
+	*TestOther
+	Name string `bencode:"name"`
+	Flag bool   `bencode:"flag"`
+}
+
+type testAmbiguous struct {
+	testBase
+	testDup
+}
+
+type testDup struct {
+	ID int `bencode:"id"`
+}
+
+func TestMarshalEmbeddedPointersBools(t *testing.T) {
+	type TestStruct struct {
+		Ptr     *int        `bencode:"ptr"`
+		Iface   interface{} `bencode:"iface"`
+		Private bool        `bencode:"private,omitempty"`
+		Flag    bool        `bencode:"flag"`
+	}
+	one := 1
+	var testCases = []struct {
+		in  interface{}
+		out string
+	}{
+		{true, "i1e"},
+		{false, "i0e"},
+		{&one, "i1e"},
+		{[]*int{&one}, "li1ee"},
+		{TestStruct{}, "d4:flagi0ee"},
+		{TestStruct{Ptr: &one, Iface: "x", Private: true, Flag: true},
+			"d4:flagi1e5:iface1:x7:privatei1e3:ptri1ee"},
+		{&TestStruct{Ptr: &one}, "d4:flagi0e3:ptri1ee"},
+		{map[string]*int{"a": nil, "b": &one}, "d1:bi1ee"},
+		{map[string]interface{}{"a": nil}, "de"},
+
+		// the outer name hides the embedded ones, extra is promoted
+		// only when the embedded pointer is set
+		{testEmbedded{testBase: testBase{ID: 1, Name: "inner"}, Name: "outer"},
+			"d4:flagi0e2:idi1e4:name5:outere"},
+		{testEmbedded{TestOther: &TestOther{Extra: "x"}},
+			"d5:extra1:x4:flagi0e2:idi0e4:name0:e"},
+		// id is ambiguous between the two embedded structs
+		{testAmbiguous{testBase{ID: 1, Name: "a"}, testDup{ID: 2}}, "d4:name1:ae"},
+	}
+	for _, tc := range testCases {
+		b, err := Marshal(tc.in)
+		if err != nil {
+			t.Fatalf("Error while marshalling %v: %v", tc.in, err)
+		}
+		if string(b) != tc.out {
+			t.Fatalf("Marshal %v err: wanted %v got %v", tc.in, tc.out, string(b))
+		}
+	}
+
+	var nilPtr *int
+	_, err := Marshal(nilPtr)
+	assertErrContains(t, err, "cannot marshal nil *int")
+	_, err = Marshal([]interface{}{nil})
+	assertErrContains(t, err, "cannot marshal nil interface")
+	_, err = Marshal(nil)
+	assertErrContains(t, err, "cannot marshal nil")
+}
@@ -0,0 +1,67 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A SyntaxError describes malformed bencode input.
+type SyntaxError struct {
+	msg    string
+	Offset int64 // offset in the input where the error was detected
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+// An UnmarshalTypeError describes a bencode value that was not appropriate
+// for the Go value it was decoded into.
+type UnmarshalTypeError struct {
+	Value string // bencode value, "integer", "bytes", "list" or "dict"
+	// Literal is the integer, or the quoted byte string, that was out of
+	// range or not a number, e.g. "300" or `"abc"`. It is empty when the
+	// type of the value is the problem.
+	Literal string
+	Type    reflect.Type // type of the Go value it could not be assigned to
+	Offset  int64        // offset in the input where the value starts
+	Field   string       // path to the value, e.g. "info.files[3].length"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	value := e.Value
+	if e.Literal != "" {
+		value += " " + e.Literal
+	}
+	msg := fmt.Sprintf("cannot unmarshal %s into %v", value, e.Type)
+	if e.Field != "" {
+		msg += " at " + e.Field
+	}
+	return fmt.Sprintf("%s (offset %d)", msg, e.Offset)
+}
+
+// pathElem is one step from a value to one of its children, either a dict
+// key or, if key is empty and index is not negative, a list index.
+type pathElem struct {
+	key   []byte
+	index int
+}
+
+func formatPath(path []pathElem) string {
+	var sb strings.Builder
+	for _, p := range path {
+		if p.index >= 0 {
+			sb.WriteByte('[')
+			sb.WriteString(strconv.Itoa(p.index))
+			sb.WriteByte(']')
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.Write(p.key)
+	}
+	return sb.String()
+}
@@ -0,0 +1,221 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// field describes how a struct field is bencoded, as given by its tag:
+//
+//	Field int `bencode:"name,omitempty,required,string"`
+//
+// omitempty leaves the key out when marshalling an empty value, required
+// makes unmarshalling fail when the key is missing and string encodes an
+// integer, or a pointer to one, as a decimal bencode string.
+type field struct {
+	name   string
+	goName string
+	// index is the path to the field through embedded structs,
+	// as for reflect.Value.FieldByIndex
+	index  []int
+	tagged bool
+
+	omitEmpty bool
+	required  bool
+	asString  bool
+}
+
+// structFields is the bencoding metadata of a struct type.
+type structFields struct {
+	// list is sorted by name, which is the order Marshal writes keys in
+	list []field
+	// byName indexes list by the dict keys that decode into each field
+	byName map[string]int
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedTypeFields is like typeFields but only does the work once per type.
+func cachedTypeFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields returns the fields of struct type t that take part in
+// bencoding. Unexported fields and fields tagged "-" are skipped. The
+// fields of untagged embedded structs are promoted following the rules
+// encoding/json uses: a field at a shallower depth hides deeper ones, and
+// at the same depth a tagged field wins or, if there isn't exactly one,
+// all fields with that name are dropped.
+func typeFields(t reflect.Type) *structFields {
+	var all []field
+	collectFields(t, nil, map[reflect.Type]bool{t: true}, &all)
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return len(all[i].index) < len(all[j].index)
+	})
+	var list []field
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].name == all[i].name {
+			j++
+		}
+		if f, ok := dominantField(all[i:j]); ok {
+			list = append(list, f)
+		}
+		i = j
+	}
+
+	// keys are matched against the tag name first and then against the
+	// Go field name, so the tag names go in last and win
+	byName := make(map[string]int, 2*len(list))
+	for i, f := range list {
+		byName[f.goName] = i
+	}
+	for i, f := range list {
+		byName[f.name] = i
+	}
+	return &structFields{list: list, byName: byName}
+}
+
+func collectFields(t reflect.Type, index []int, visited map[reflect.Type]bool, fields *[]field) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		ft := sf.Type
+		if sf.Anonymous && ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.PkgPath != "" && !(sf.Anonymous && ft.Kind() == reflect.Struct) {
+			// unexported, but the exported fields of an unexported
+			// embedded struct are still promoted
+			continue
+		}
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			if !visited[ft] {
+				visited[ft] = true
+				collectFields(ft, fieldIndex, visited, fields)
+				delete(visited, ft)
+			}
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+		asString := opts.Contains("string")
+		if asString && !isIntegerKind(sf.Type.Kind()) &&
+			!(sf.Type.Kind() == reflect.Ptr && isIntegerKind(sf.Type.Elem().Kind())) {
+			// the decoder and encoder panic with errors, this ends
+			// up as the error of Marshal or Unmarshal
+			panic(fmt.Errorf("string option on field %s of type %v, which is not an integer", sf.Name, sf.Type))
+		}
+		*fields = append(*fields, field{
+			name:      name,
+			goName:    sf.Name,
+			index:     fieldIndex,
+			tagged:    tagged,
+			omitEmpty: opts.Contains("omitempty"),
+			required:  opts.Contains("required"),
+			asString:  asString,
+		})
+	}
+}
+
+// dominantField picks the field that a name refers to out of fields with
+// that name, sorted by depth. It reports false if the name is ambiguous.
+func dominantField(fields []field) (field, bool) {
+	depth := len(fields[0].index)
+	n := 0
+	for n < len(fields) && len(fields[n].index) == depth {
+		n++
+	}
+	fields = fields[:n]
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var tagged []field
+	for _, f := range fields {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+// fieldByIndex returns the field of struct v at index, or false if it is
+// in an embedded struct behind a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc is like fieldByIndex but allocates nil pointers to
+// embedded structs on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					panic(fmt.Errorf("cannot set embedded pointer to unexported struct %v",
+						v.Type().Elem()))
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
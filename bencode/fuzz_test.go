@@ -0,0 +1,91 @@
+package bencode
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// addFuzzSeeds seeds a fuzz target with the files in testdata and a few
+// small values covering every kind.
+func addFuzzSeeds(f *testing.F) {
+	files, err := filepath.Glob("../testdata/*.torrent")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	for _, s := range []string{
+		"i-42e", "4:spam", "le", "de", "li1e3:fooe", "d1:ad1:bli0eeee",
+		"d1:bi1e1:ai2ee", "i18446744073709551615e", "i03e", "03:abc",
+	} {
+		f.Add([]byte(s))
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		d := NewBytesDecoder(data)
+		if err := d.Decode(&v); err != nil {
+			return
+		}
+		// Unmarshal ignores anything after the first value.
+		if n := d.InputOffset(); !Valid(data[:n]) {
+			t.Fatalf("Unmarshal accepted %q but Valid rejects it", data[:n])
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := Unmarshal(data, &v); err != nil {
+			return
+		}
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal of %#v failed: %v", v, err)
+		}
+		if !IsCanonical(b) {
+			t.Fatalf("Marshal produced non-canonical %q", b)
+		}
+		if IsCanonical(data) && !bytes.Equal(b, data) {
+			t.Fatalf("canonical %q changed to %q", data, b)
+		}
+		var v2 interface{}
+		if err := Unmarshal(b, &v2); err != nil {
+			t.Fatalf("Unmarshal of marshaled %q failed: %v", b, err)
+		}
+		if !reflect.DeepEqual(v, v2) {
+			t.Fatalf("round trip changed %#v to %#v", v, v2)
+		}
+	})
+}
+
+func FuzzJSON(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		j, err := ToJSON(data)
+		lossy := err == ErrLossyJSON
+		if err != nil && !lossy {
+			return
+		}
+		b, err := FromJSON(j)
+		if err != nil {
+			t.Fatalf("FromJSON(%s) failed: %v", j, err)
+		}
+		if !lossy && !bytes.Equal(b, data) {
+			t.Fatalf("%q changed to %q through %s", data, b, j)
+		}
+	})
+}
@@ -0,0 +1,398 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Names of the JSON objects that stand for bencode values with no direct
+// JSON equivalent.
+const (
+	jsonHex    = "$hex"
+	jsonBase64 = "$base64"
+	jsonDict   = "$dict"
+)
+
+// Binary strings up to this long are converted to hex, longer ones to the
+// more compact base64.
+const maxJSONHex = 64
+
+// ToJSON converts a single bencode value to JSON. The mapping is:
+//
+//   - integers become numbers with the same digits
+//   - strings that are valid UTF-8 become JSON strings, other strings become
+//     {"$hex": "..."} if they are at most 64 bytes long and
+//     {"$base64": "..."} otherwise
+//   - lists become arrays
+//   - dicts become objects with the keys in their original order, unless a
+//     key is not valid UTF-8, a key is repeated or the only key is "$hex",
+//     "$base64" or "$dict". Those dicts become {"$dict": [[key, value], ...]}
+//     with the keys converted like any other string.
+//
+// FromJSON reverses the mapping, so converting back gives the original
+// bytes. Integers with leading zeros or "-0" and string lengths with
+// leading zeros have no JSON form of their own. They are written in their
+// canonical form and ToJSON returns ErrLossyJSON along with the JSON, as
+// converting it back gives different bytes.
+func ToJSON(data []byte) ([]byte, error) {
+	w := jsonWriter{s: NewBytesScanner(data), data: data}
+	tok, err := w.s.Next()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := w.value(tok); err != nil {
+		return nil, err
+	}
+	if w.s.InputOffset() != int64(len(data)) {
+		return nil, errors.New("trailing data after top-level value")
+	}
+	if w.lossy {
+		return w.buf, ErrLossyJSON
+	}
+	return w.buf, nil
+}
+
+// ErrLossyJSON is returned by ToJSON, together with the JSON, for input
+// that isn't given back exactly by FromJSON.
+var ErrLossyJSON = errors.New("non-canonical integers or string lengths were normalized")
+
+type jsonWriter struct {
+	s     *Scanner
+	data  []byte
+	buf   []byte
+	lossy bool // a value was normalized
+}
+
+func (w *jsonWriter) value(tok Token) error {
+	switch tok.Kind {
+	case Int:
+		digits := tok.Bytes
+		neg := digits[0] == '-'
+		if neg {
+			digits = digits[1:]
+		}
+		if digits[0] == '0' && len(tok.Bytes) > 1 {
+			w.lossy = true
+			digits = bytes.TrimLeft(digits, "0")
+			if len(digits) == 0 {
+				digits, neg = []byte("0"), false
+			}
+		}
+		if neg {
+			w.buf = append(w.buf, '-')
+		}
+		w.buf = append(w.buf, digits...)
+	case String:
+		if w.data[tok.Offset] == '0' && len(tok.Bytes) > 0 {
+			w.lossy = true
+		}
+		w.buf = appendJSONBytes(w.buf, tok.Bytes)
+	case ListStart:
+		w.buf = append(w.buf, '[')
+		for i := 0; ; i++ {
+			tok, err := w.s.Next()
+			if err != nil {
+				return err
+			}
+			if tok.Kind == End {
+				break
+			}
+			if i > 0 {
+				w.buf = append(w.buf, ',')
+			}
+			if err := w.value(tok); err != nil {
+				return err
+			}
+		}
+		w.buf = append(w.buf, ']')
+	case DictStart:
+		return w.dict()
+	}
+	return nil
+}
+
+// dict writes a dict as an object and rewrites it in the "$dict" form if
+// it turns out it can't be one.
+func (w *jsonWriter) dict() error {
+	type entry struct {
+		key        []byte
+		start, end int // position of the value in w.buf
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	object := true
+
+	start := len(w.buf)
+	w.buf = append(w.buf, '{')
+	for {
+		tok, err := w.s.Next()
+		if err != nil {
+			return err
+		}
+		if tok.Kind == End {
+			break
+		}
+		if len(entries) > 0 {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = appendJSONBytes(w.buf, tok.Bytes)
+		w.buf = append(w.buf, ':')
+		e := entry{key: tok.Bytes, start: len(w.buf)}
+		if tok, err = w.s.Next(); err != nil {
+			return err
+		}
+		if err := w.value(tok); err != nil {
+			return err
+		}
+		e.end = len(w.buf)
+		entries = append(entries, e)
+
+		if !utf8.Valid(e.key) || seen[string(e.key)] {
+			object = false
+		}
+		seen[string(e.key)] = true
+	}
+	if len(entries) == 1 && isJSONTag(string(entries[0].key)) {
+		object = false
+	}
+	if object {
+		w.buf = append(w.buf, '}')
+		return nil
+	}
+
+	written := append([]byte(nil), w.buf[start:]...)
+	w.buf = append(w.buf[:start], `{"`+jsonDict+`":[`...)
+	for i, e := range entries {
+		if i > 0 {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = append(w.buf, '[')
+		w.buf = appendJSONBytes(w.buf, e.key)
+		w.buf = append(w.buf, ',')
+		w.buf = append(w.buf, written[e.start-start:e.end-start]...)
+		w.buf = append(w.buf, ']')
+	}
+	w.buf = append(w.buf, "]}"...)
+	return nil
+}
+
+func isJSONTag(name string) bool {
+	return name == jsonHex || name == jsonBase64 || name == jsonDict
+}
+
+func appendJSONBytes(buf, b []byte) []byte {
+	if utf8.Valid(b) {
+		return appendJSONString(buf, b)
+	}
+	if len(b) <= maxJSONHex {
+		buf = append(buf, `{"`+jsonHex+`":"`...)
+		n := len(buf)
+		buf = append(buf, make([]byte, hex.EncodedLen(len(b)))...)
+		hex.Encode(buf[n:], b)
+	} else {
+		buf = append(buf, `{"`+jsonBase64+`":"`...)
+		n := len(buf)
+		buf = append(buf, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+		base64.StdEncoding.Encode(buf[n:], b)
+	}
+	return append(buf, `"}`...)
+}
+
+// appendJSONString quotes a valid UTF-8 string, escaping only what JSON
+// requires so that the text stays readable.
+func appendJSONString(buf, s []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	buf = append(buf, '"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
+
+// FromJSON converts JSON produced by ToJSON, possibly edited since, back to
+// bencode. See ToJSON for the mapping. Dict keys are written in the order
+// they appear in, so the result is only canonical if they are sorted.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after top-level JSON value")
+	}
+	return appendJSONValue(nil, v)
+}
+
+// jsonObject is a JSON object with its members in order.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	name  string
+	value interface{}
+}
+
+// readJSON reads a JSON value as a json.Number, string, []interface{} or
+// jsonObject. Other values have no bencode equivalent and are an error.
+func readJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Number, string:
+		return tok, nil
+	case json.Delim:
+		switch tok {
+		case '[':
+			l := []interface{}{}
+			for dec.More() {
+				v, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, v)
+			}
+			_, err := dec.Token()
+			return l, err
+		case '{':
+			o := jsonObject{}
+			for dec.More() {
+				name, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := readJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				o = append(o, jsonMember{name.(string), v})
+			}
+			_, err := dec.Token()
+			return o, err
+		}
+	}
+	return nil, fmt.Errorf("JSON %v has no bencode equivalent at offset %d", tok, dec.InputOffset())
+}
+
+func appendJSONValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case json.Number:
+		// JSON already rules out leading zeros
+		if strings.ContainsAny(string(v), ".eE") || v == "-0" {
+			return nil, fmt.Errorf("JSON number %v is not a bencode integer", v)
+		}
+		buf = append(buf, 'i')
+		buf = append(buf, v...)
+		return append(buf, 'e'), nil
+	case string:
+		return appendString(buf, []byte(v)), nil
+	case []interface{}:
+		buf = append(buf, 'l')
+		for _, e := range v {
+			var err error
+			if buf, err = appendJSONValue(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, 'e'), nil
+	case jsonObject:
+		if len(v) == 1 && isJSONTag(v[0].name) {
+			return appendJSONTagged(buf, v[0])
+		}
+		buf = append(buf, 'd')
+		for _, m := range v {
+			buf = appendString(buf, []byte(m.name))
+			var err error
+			if buf, err = appendJSONValue(buf, m.value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, 'e'), nil
+	}
+	panic("unreachable")
+}
+
+func appendJSONTagged(buf []byte, m jsonMember) ([]byte, error) {
+	if m.name == jsonDict {
+		pairs, ok := m.value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of [key, value] pairs", jsonDict)
+		}
+		buf = append(buf, 'd')
+		for _, p := range pairs {
+			pair, ok := p.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("%s must be a list of [key, value] pairs", jsonDict)
+			}
+			key, err := jsonBytes(pair[0])
+			if err != nil {
+				return nil, err
+			}
+			buf = appendString(buf, key)
+			if buf, err = appendJSONValue(buf, pair[1]); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, 'e'), nil
+	}
+	b, err := jsonBytes(jsonObject{m})
+	if err != nil {
+		return nil, err
+	}
+	return appendString(buf, b), nil
+}
+
+// jsonBytes returns the contents of a JSON string or a "$hex" or "$base64"
+// object.
+func jsonBytes(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case jsonObject:
+		if len(v) == 1 {
+			s, ok := v[0].value.(string)
+			switch {
+			case ok && v[0].name == jsonHex:
+				return hex.DecodeString(s)
+			case ok && v[0].name == jsonBase64:
+				return base64.StdEncoding.DecodeString(s)
+			}
+		}
+	}
+	return nil, fmt.Errorf("expected a string, %s or %s object", jsonHex, jsonBase64)
+}
+
+func appendString(buf, s []byte) []byte {
+	buf = strconv.AppendInt(buf, int64(len(s)), 10)
+	buf = append(buf, ':')
+	return append(buf, s...)
+}
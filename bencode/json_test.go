@@ -0,0 +1,151 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	long := bytes.Repeat([]byte{0xff}, 65)
+	var testCases = []struct {
+		in  string
+		out string
+	}{
+		{"i-42e", `-42`},
+		{"i18446744073709551616e", `18446744073709551616`},
+		{"5:hello", `"hello"`},
+		{"0:", `""`},
+		{"4:a\"\n\x01", `"a\"\n\u0001"`},
+		{"3:\xff\x00\x01", `{"$hex":"ff0001"}`},
+		{"65:" + string(long), `{"$base64":"` + base64.StdEncoding.EncodeToString(long) + `"}`},
+		{"li1e1:alee", `[1,"a",[]]`},
+		{"d1:bi1e1:ai2ee", `{"b":1,"a":2}`},
+		{"d4:$hex3:abce", `{"$dict":[["$hex","abc"]]}`},
+		{"d4:$hex3:abc1:xi1ee", `{"$hex":"abc","x":1}`},
+		{"d1:ai1e1:ai2ee", `{"$dict":[["a",1],["a",2]]}`},
+		{"d1:\xffd1:ai1eee", `{"$dict":[[{"$hex":"ff"},{"a":1}]]}`},
+	}
+
+	for _, tt := range testCases {
+		out, err := ToJSON([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("ToJSON(%q) unexpected error: %v", tt.in, err)
+		}
+		if string(out) != tt.out {
+			t.Fatalf("ToJSON(%q): wanted %s got %s", tt.in, tt.out, out)
+		}
+		back, err := FromJSON(out)
+		if err != nil {
+			t.Fatalf("FromJSON(%s) unexpected error: %v", out, err)
+		}
+		if string(back) != tt.in {
+			t.Fatalf("FromJSON(%s): wanted %q got %q", out, tt.in, back)
+		}
+	}
+}
+
+func TestToJSONInvalid(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		err string
+	}{
+		{"", "unexpected EOF"},
+		{"i1ei2e", "trailing data"},
+		{"li1e", "unexpected EOF"},
+	}
+
+	for _, tt := range testCases {
+		_, err := ToJSON([]byte(tt.in))
+		assertErrContains(t, err, tt.err)
+	}
+}
+
+func TestToJSONLossy(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		out string
+	}{
+		{"i03e", `3`},
+		{"i-0e", `0`},
+		{"i-007e", `-7`},
+		{"i000e", `0`},
+		{"03:abc", `"abc"`},
+		{"li1ed1:a02:bcee", `[1,{"a":"bc"}]`},
+	}
+
+	for _, tt := range testCases {
+		out, err := ToJSON([]byte(tt.in))
+		if err != ErrLossyJSON {
+			t.Fatalf("ToJSON(%q): expected ErrLossyJSON, got %v", tt.in, err)
+		}
+		if string(out) != tt.out {
+			t.Fatalf("ToJSON(%q): wanted %s got %s", tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		out string
+	}{
+		{` { "z" : [ 1 , "x" ] , "a" : {} } `, "d1:zli1e1:xe1:adee"},
+		{`{"$base64":"/w=="}`, "1:\xff"},
+		{`{"$dict":[[{"$base64":"/w=="},1]]}`, "d1:\xffi1ee"},
+		{`"é"`, "2:\xc3\xa9"},
+	}
+
+	for _, tt := range testCases {
+		out, err := FromJSON([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("FromJSON(%s) unexpected error: %v", tt.in, err)
+		}
+		if string(out) != tt.out {
+			t.Fatalf("FromJSON(%s): wanted %q got %q", tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestFromJSONInvalid(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		err string
+	}{
+		{`1.5`, "JSON number 1.5 is not a bencode integer"},
+		{`1e3`, "JSON number 1e3 is not a bencode integer"},
+		{`-0`, "JSON number -0 is not a bencode integer"},
+		{`[true]`, "JSON true has no bencode equivalent"},
+		{`{"a":null}`, "has no bencode equivalent"},
+		{`{"$hex":"zz"}`, "invalid byte"},
+		{`{"$hex":1}`, "expected a string"},
+		{`{"$dict":[["a"]]}`, "list of [key, value] pairs"},
+		{`{"$dict":{}}`, "list of [key, value] pairs"},
+		{`1 2`, "trailing data"},
+		{`[1`, "unexpected end of JSON input"},
+	}
+
+	for _, tt := range testCases {
+		_, err := FromJSON([]byte(tt.in))
+		assertErrContains(t, err, tt.err)
+	}
+}
+
+func TestJSONRoundTripTorrent(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/ubuntu-17.10.1-desktop-amd64.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := ToJSON(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	back, err := FromJSON(j)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Fatal("torrent changed after converting to JSON and back")
+	}
+}
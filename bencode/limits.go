@@ -0,0 +1,38 @@
+package bencode
+
+import "fmt"
+
+// Limits bound the resources a Decoder spends on a single value, which
+// matters when the input comes from untrusted peers and trackers. A zero
+// field means no limit, except for MaxDepth which falls back to
+// DefaultMaxDepth because unbounded nesting would overflow the stack.
+type Limits struct {
+	MaxStringLength int64 // longest string, in bytes
+	MaxDepth        int   // deepest nesting of lists and dicts
+	MaxElements     int64 // total number of values, including dict keys
+	MaxInputSize    int64 // total encoded size, in bytes
+}
+
+const DefaultMaxDepth = 1000
+
+// DefaultLimits are the limits used by Unmarshal and new Decoders.
+var DefaultLimits = Limits{MaxDepth: DefaultMaxDepth}
+
+func (l Limits) maxDepth() int {
+	if l.MaxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return l.MaxDepth
+}
+
+// A LimitError is returned when decoding a value would exceed one of the
+// Decoder's Limits.
+type LimitError struct {
+	Limit  string // which limit was exceeded, e.g. "string length"
+	Max    int64  // the value of the limit
+	Offset int64  // offset in the input where the limit was exceeded
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeds limit of %d at offset %d", e.Limit, e.Max, e.Offset)
+}
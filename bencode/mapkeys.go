@@ -0,0 +1,88 @@
+package bencode
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Dict keys are always strings, so maps are restricted to key types that
+// convert to and from one. In order of precedence those are string types,
+// which are used as they are, types implementing encoding.TextMarshaler
+// and encoding.TextUnmarshaler, and integer types, which are written in
+// decimal. Keys are sorted by their encoding, so integer keys do not come
+// out in numeric order.
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func canMarshalKey(t reflect.Type) bool {
+	return t.Kind() == reflect.String || t.Implements(textMarshalerType) ||
+		isIntegerKind(t.Kind())
+}
+
+func canUnmarshalKey(t reflect.Type) bool {
+	return t.Kind() == reflect.String ||
+		reflect.PtrTo(t).Implements(textUnmarshalerType) ||
+		isIntegerKind(t.Kind())
+}
+
+// marshalKey returns the dict key for map key k.
+func marshalKey(k reflect.Value) []byte {
+	if k.Kind() == reflect.String {
+		return []byte(k.String())
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if isNilPtr(k) {
+			return []byte{}
+		}
+		b, err := tm.MarshalText()
+		if err != nil {
+			panic(fmt.Errorf("cannot marshal map key %v: %v", k.Interface(), err))
+		}
+		return b
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(nil, k.Uint(), 10)
+	}
+	panic(fmt.Errorf("cannot marshal map with key type %v", k.Type()))
+}
+
+// unmarshalKey converts dict key key to a map key of type t.
+func (us *unmarshalState) unmarshalKey(key []byte, t reflect.Type) reflect.Value {
+	kv := reflect.New(t).Elem()
+	if t.Kind() == reflect.String {
+		kv.SetString(string(key))
+		return kv
+	}
+	if tu, ok := kv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := tu.UnmarshalText(key); err != nil {
+			panic(fmt.Errorf("cannot unmarshal dict key %q into %v%s: %v",
+				key, t, us.pathSuffix(), err))
+		}
+		return kv
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(key), 10, t.Bits())
+		if err != nil {
+			panic(us.typeError("bytes", strconv.Quote(string(key)), kv))
+		}
+		kv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(string(key), 10, t.Bits())
+		if err != nil {
+			panic(us.typeError("bytes", strconv.Quote(string(key)), kv))
+		}
+		kv.SetUint(n)
+	default:
+		panic(fmt.Errorf("cannot unmarshal map with key type %v", t))
+	}
+	return kv
+}
@@ -0,0 +1,21 @@
+package bencode
+
+import "errors"
+
+// RawMessage is a raw encoded bencode value. Unmarshal fills it with the
+// exact bytes of the value from the input and Marshal writes them back
+// unchanged, so it can be used to delay decoding or to hash a value as it
+// appeared on the wire.
+type RawMessage []byte
+
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("cannot marshal empty RawMessage")
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
@@ -0,0 +1,198 @@
+package bencode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type TokenKind int
+
+const (
+	DictStart TokenKind = iota + 1
+	ListStart
+	Int
+	String
+	End
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case DictStart:
+		return "DictStart"
+	case ListStart:
+		return "ListStart"
+	case Int:
+		return "Int"
+	case String:
+		return "String"
+	case End:
+		return "End"
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A Token is one element of a bencode document as read by a Scanner.
+type Token struct {
+	Kind   TokenKind
+	Offset int64 // offset of the first byte of the token in the input
+	// Bytes holds the contents of a String or the decimal digits of an
+	// Int. Scanners from NewBytesScanner make it alias the input.
+	Bytes []byte
+}
+
+// Int64 returns the value of an Int token.
+func (t Token) Int64() (int64, error) {
+	if t.Kind != Int {
+		return 0, fmt.Errorf("token is %v, not Int", t.Kind)
+	}
+	return strconv.ParseInt(string(t.Bytes), 10, 64)
+}
+
+// A Scanner reads a bencode stream one token at a time, without decoding
+// it into Go values. It checks that the tokens form well-formed values, so
+// for example a dict key is always a String, and applies the same Limits
+// as a Decoder to each top-level value.
+type Scanner struct {
+	us    unmarshalState
+	stack []scanFrame
+}
+
+type scanFrame struct {
+	kind TokenKind // DictStart or ListStart
+	n    int       // number of tokens at this level so far
+	prev []byte    // previous dict key
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{us: unmarshalState{r: bufio.NewReader(r), limits: DefaultLimits}}
+}
+
+func NewBytesScanner(data []byte) *Scanner {
+	return &Scanner{us: unmarshalState{data: data, limits: DefaultLimits}}
+}
+
+func (s *Scanner) SetLimits(l Limits) {
+	s.us.limits = l
+}
+
+// DisallowNonCanonical makes the Scanner reject non-canonical input the
+// same way Decoder.DisallowNonCanonical does.
+func (s *Scanner) DisallowNonCanonical() {
+	s.us.strict = true
+}
+
+// InputOffset returns the number of bytes consumed so far, the offset just
+// past the last token or skipped value.
+func (s *Scanner) InputOffset() int64 {
+	return s.us.off
+}
+
+// Depth returns the number of lists and dicts the scanner is inside of.
+func (s *Scanner) Depth() int {
+	return len(s.stack)
+}
+
+// Next returns the next token. It returns io.EOF if the input ends between
+// top-level values and io.ErrUnexpectedEOF if it ends inside one.
+func (s *Scanner) Next() (tok Token, err error) {
+	defer handlePanic(&err)
+	if len(s.stack) == 0 {
+		if err := s.us.more(); err != nil {
+			return Token{}, err
+		}
+		s.us.reset()
+	}
+
+	us := &s.us
+	tok.Offset = us.off
+	b := us.peekByte()
+	if b == 'e' {
+		if len(s.stack) == 0 {
+			panic(us.syntaxError("unexpected end of container"))
+		}
+		top := s.stack[len(s.stack)-1]
+		if top.kind == DictStart && top.n%2 == 1 {
+			panic(us.syntaxError("missing value for dict key"))
+		}
+		us.skipByte()
+		us.depth--
+		s.stack = s.stack[:len(s.stack)-1]
+		tok.Kind = End
+		return tok, nil
+	}
+
+	isKey := s.atKey()
+	if isKey && !isDigit(b) {
+		panic(us.syntaxError(fmt.Sprintf("unexpected character %q in dict key", b)))
+	}
+	us.countElement()
+	switch {
+	case b == 'd' || b == 'l':
+		us.skipByte()
+		us.enter()
+		s.count(nil)
+		tok.Kind = ListStart
+		if b == 'd' {
+			tok.Kind = DictStart
+		}
+		s.stack = append(s.stack, scanFrame{kind: tok.Kind})
+	case b == 'i':
+		us.skipByte()
+		tok.Kind = Int
+		tok.Bytes = []byte(us.readInt())
+		s.count(nil)
+	case isDigit(b):
+		tok.Kind = String
+		tok.Bytes = us.next(us.readLength())
+		if isKey {
+			s.count(tok.Bytes)
+		} else {
+			s.count(nil)
+		}
+	default:
+		panic(us.syntaxError(fmt.Sprintf("unexpected character %q", b)))
+	}
+	return tok, nil
+}
+
+// SkipValue consumes the next value, however deeply nested, without
+// returning its tokens. It can't be used where a dict key is expected.
+func (s *Scanner) SkipValue() (err error) {
+	defer handlePanic(&err)
+	if len(s.stack) == 0 {
+		if err := s.us.more(); err != nil {
+			return err
+		}
+		s.us.reset()
+	} else if s.atKey() {
+		return fmt.Errorf("cannot skip a dict key at offset %d", s.us.off)
+	}
+	s.us.skipValue()
+	s.count(nil)
+	return nil
+}
+
+// atKey reports whether the next token is expected to be a dict key.
+func (s *Scanner) atKey() bool {
+	if len(s.stack) == 0 {
+		return false
+	}
+	top := s.stack[len(s.stack)-1]
+	return top.kind == DictStart && top.n%2 == 0
+}
+
+// count records a token or value in the current container, key is the
+// key if it was one.
+func (s *Scanner) count(key []byte) {
+	if len(s.stack) == 0 {
+		return
+	}
+	top := &s.stack[len(s.stack)-1]
+	if key != nil {
+		s.us.checkKeyOrder(top.n/2, top.prev, key, s.us.off-int64(len(key)))
+		top.prev = key
+	}
+	top.n++
+}
@@ -0,0 +1,133 @@
+package bencode
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	in := "d3:fooli1e2:abe3:bari-7eei0e"
+	var expected = []struct {
+		kind   TokenKind
+		offset int64
+		bytes  string
+	}{
+		{DictStart, 0, ""},
+		{String, 1, "foo"},
+		{ListStart, 6, ""},
+		{Int, 7, "1"},
+		{String, 10, "ab"},
+		{End, 14, ""},
+		{String, 15, "bar"},
+		{Int, 20, "-7"},
+		{End, 24, ""},
+		{Int, 25, "0"},
+	}
+
+	scanners := map[string]*Scanner{
+		"stream": NewScanner(strings.NewReader(in)),
+		"bytes":  NewBytesScanner([]byte(in)),
+	}
+	for name, s := range scanners {
+		for _, tt := range expected {
+			tok, err := s.Next()
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			if tok.Kind != tt.kind || tok.Offset != tt.offset || string(tok.Bytes) != tt.bytes {
+				t.Fatalf("%s: wanted %v at %d %q, got %v at %d %q", name,
+					tt.kind, tt.offset, tt.bytes, tok.Kind, tok.Offset, tok.Bytes)
+			}
+		}
+		if _, err := s.Next(); err != io.EOF {
+			t.Fatalf("%s: expected io.EOF, got %v", name, err)
+		}
+	}
+}
+
+func TestScannerSkipValue(t *testing.T) {
+	in := "d4:infod6:lengthi1e4:name1:ae3:urll1:x1:yee"
+	s := NewBytesScanner([]byte(in))
+	if tok, err := s.Next(); err != nil || tok.Kind != DictStart {
+		t.Fatalf("expected DictStart, got %v %v", tok.Kind, err)
+	}
+	if err := s.SkipValue(); err == nil {
+		t.Fatal("expected error skipping a dict key")
+	}
+	var start, end int64
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tok.Kind == End {
+			break
+		}
+		off := s.InputOffset()
+		if err := s.SkipValue(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(tok.Bytes) == "info" {
+			start, end = off, s.InputOffset()
+		}
+	}
+	if info := in[start:end]; start != 7 || info != "d6:lengthi1e4:name1:ae" {
+		t.Fatalf("wrong info range %d-%d: %q", start, end, in[start:end])
+	}
+}
+
+func TestScannerInt64(t *testing.T) {
+	tok, err := NewBytesScanner([]byte("i-42e")).Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := tok.Int64(); err != nil || n != -42 {
+		t.Fatalf("wanted -42, got %v %v", n, err)
+	}
+	_, err = Token{Kind: String}.Int64()
+	assertErrContains(t, err, "token is String, not Int")
+}
+
+func TestScannerInvalid(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		err string
+	}{
+		{"e", "unexpected end of container at offset 0"},
+		{"di1ei2ee", "unexpected character 'i' in dict key at offset 1"},
+		{"d1:ae", "missing value for dict key at offset 4"},
+		{"lx", "unexpected character 'x' at offset 1"},
+		{"ixe", "unexpected character 'x'"},
+		{"l1:a", "unexpected EOF"},
+	}
+
+	for _, tt := range testCases {
+		s := NewBytesScanner([]byte(tt.in))
+		var err error
+		for err == nil {
+			_, err = s.Next()
+		}
+		assertErrContains(t, err, tt.err)
+	}
+}
+
+func TestScannerDisallowNonCanonical(t *testing.T) {
+	s := NewBytesScanner([]byte("d1:bi1e1:ai2ee"))
+	s.DisallowNonCanonical()
+	var err error
+	for err == nil {
+		_, err = s.Next()
+	}
+	assertErrContains(t, err, `dict key "a" is not sorted after "b"`)
+}
+
+func TestScannerLimits(t *testing.T) {
+	s := NewBytesScanner([]byte("llleeeli1ei2ee"))
+	s.SetLimits(Limits{MaxDepth: 2})
+	var err error
+	for err == nil {
+		_, err = s.Next()
+	}
+	assertErrContains(t, err, "nesting depth")
+}
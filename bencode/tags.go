@@ -0,0 +1,31 @@
+package bencode
+
+import "strings"
+
+// tagOptions is the string following a comma in a struct field's "bencode"
+// tag, or the empty string.
+type tagOptions string
+
+// parseTag splits a struct field's bencode tag into its name and
+// comma-separated options.
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+	return tag, tagOptions("")
+}
+
+func (o tagOptions) Contains(optionName string) bool {
+	s := string(o)
+	for s != "" {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == optionName {
+			return true
+		}
+		s = next
+	}
+	return false
+}
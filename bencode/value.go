@@ -0,0 +1,172 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type Kind int
+
+const (
+	KindInvalid Kind = iota
+	KindInt
+	KindString
+	KindList
+	KindDict
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInt:
+		return "integer"
+	case KindString:
+		return "bytes"
+	case KindList:
+		return "list"
+	case KindDict:
+		return "dict"
+	}
+	return "invalid"
+}
+
+// Value is a lazily decoded bencode value. It keeps the encoded bytes and
+// only decodes the parts that are asked for, so a large document can be
+// walked without building maps and slices for all of it. The byte slices a
+// Value returns alias its encoding.
+type Value struct {
+	raw []byte
+}
+
+// ParseValue checks that data is exactly one bencode value and returns it
+// as a Value aliasing data.
+func ParseValue(data []byte) (Value, error) {
+	if err := checkValid(data, false); err != nil {
+		return Value{}, err
+	}
+	return Value{data}, nil
+}
+
+// walkLimits are used when walking a Value, whose encoding has already been
+// checked, possibly against looser limits than the default ones.
+var walkLimits = Limits{MaxDepth: int(^uint(0) >> 1)}
+
+// Raw returns the encoding of the value.
+func (v Value) Raw() []byte {
+	return v.raw
+}
+
+func (v Value) Kind() Kind {
+	if len(v.raw) == 0 {
+		return KindInvalid
+	}
+	switch b := v.raw[0]; {
+	case b == 'i':
+		return KindInt
+	case b == 'l':
+		return KindList
+	case b == 'd':
+		return KindDict
+	case isDigit(b):
+		return KindString
+	}
+	return KindInvalid
+}
+
+func (v Value) Int() (int64, error) {
+	if err := v.expect(KindInt); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(v.raw[1:len(v.raw)-1]), 10, 64)
+}
+
+func (v Value) Bytes() ([]byte, error) {
+	if err := v.expect(KindString); err != nil {
+		return nil, err
+	}
+	return v.raw[bytes.IndexByte(v.raw, ':')+1:], nil
+}
+
+// List returns the elements of a list.
+func (v Value) List() ([]Value, error) {
+	if err := v.expect(KindList); err != nil {
+		return nil, err
+	}
+	var elems []Value
+	err := v.each(func(_ []byte, elem Value) bool {
+		elems = append(elems, elem)
+		return true
+	})
+	return elems, err
+}
+
+// Get returns the value of key in a dict. It reports false if v is not a
+// dict or has no such key.
+func (v Value) Get(key string) (Value, bool) {
+	if v.Kind() != KindDict {
+		return Value{}, false
+	}
+	var res Value
+	var found bool
+	v.each(func(k []byte, elem Value) bool {
+		if string(k) == key {
+			res, found = elem, true
+		}
+		return !found
+	})
+	return res, found
+}
+
+// Dict calls fn for each key and value of a dict, in the order they were
+// encoded, until fn returns false.
+func (v Value) Dict(fn func(key []byte, val Value) bool) error {
+	if err := v.expect(KindDict); err != nil {
+		return err
+	}
+	return v.each(fn)
+}
+
+// Decode unmarshals the value into x.
+func (v Value) Decode(x interface{}) error {
+	return Unmarshal(v.raw, x)
+}
+
+func (v Value) MarshalBencode() ([]byte, error) {
+	if len(v.raw) == 0 {
+		return nil, errors.New("cannot marshal empty Value")
+	}
+	return v.raw, nil
+}
+
+func (v *Value) UnmarshalBencode(data []byte) error {
+	v.raw = append([]byte(nil), data...)
+	return nil
+}
+
+func (v Value) expect(k Kind) error {
+	if v.Kind() != k {
+		return fmt.Errorf("bencode value is %v, not %v", v.Kind(), k)
+	}
+	return nil
+}
+
+// each walks the elements of a list or a dict, keys are nil for lists.
+func (v Value) each(fn func(key []byte, elem Value) bool) (err error) {
+	defer handlePanic(&err)
+	us := unmarshalState{data: v.raw, limits: walkLimits}
+	isDict := us.peekByte() == 'd'
+	us.skipByte()
+	for us.peekByte() != 'e' {
+		var key []byte
+		if isDict {
+			key = us.next(us.readLength())
+		}
+		start := us.off
+		us.skipValue()
+		if !fn(key, Value{v.raw[start:us.off]}) {
+			break
+		}
+	}
+	return
+}
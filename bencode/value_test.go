@@ -0,0 +1,127 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	in := "d4:infod6:lengthi42e6:pieces4:abcde4:listli1e2:xyee"
+	v, err := ParseValue([]byte(in))
+	if err != nil {
+		t.Fatalf("Error while parsing %v: %v", in, err)
+	}
+	if v.Kind() != KindDict {
+		t.Fatalf("expected dict, got %v", v.Kind())
+	}
+
+	info, ok := v.Get("info")
+	if !ok {
+		t.Fatal("info not found")
+	}
+	length, ok := info.Get("length")
+	if !ok {
+		t.Fatal("info.length not found")
+	}
+	n, err := length.Int()
+	if err != nil || n != 42 {
+		t.Fatalf("expected length 42, got %v (%v)", n, err)
+	}
+	pieces, _ := info.Get("pieces")
+	b, err := pieces.Bytes()
+	if err != nil || string(b) != "abcd" {
+		t.Fatalf("expected pieces abcd, got %q (%v)", b, err)
+	}
+	if _, ok := info.Get("name"); ok {
+		t.Fatal("unexpected name key")
+	}
+	if _, ok := length.Get("x"); ok {
+		t.Fatal("Get on an integer should not find anything")
+	}
+
+	list, _ := v.Get("list")
+	elems, err := list.List()
+	if err != nil || len(elems) != 2 {
+		t.Fatalf("expected 2 list elements, got %v (%v)", elems, err)
+	}
+	if string(elems[1].Raw()) != "2:xy" {
+		t.Fatalf("expected 2:xy, got %s", elems[1].Raw())
+	}
+	if _, err := elems[0].Bytes(); err == nil {
+		t.Fatal("expected error calling Bytes on an integer")
+	}
+
+	var keys []string
+	err = v.Dict(func(key []byte, _ Value) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if err != nil || !reflect.DeepEqual(keys, []string{"info", "list"}) {
+		t.Fatalf("expected keys [info list], got %v (%v)", keys, err)
+	}
+
+	var res struct {
+		Length int `bencode:"length"`
+	}
+	if err := info.Decode(&res); err != nil || res.Length != 42 {
+		t.Fatalf("expected decoded length 42, got %v (%v)", res.Length, err)
+	}
+
+	if _, err := ParseValue([]byte("d1:ai1e")); err == nil {
+		t.Fatal("expected error parsing truncated value")
+	}
+}
+
+func TestValueField(t *testing.T) {
+	type TestStruct struct {
+		Info Value `bencode:"info"`
+	}
+	in := []byte("d4:infod1:ai1eee")
+
+	var res TestStruct
+	if err := Unmarshal(in, &res); err != nil {
+		t.Fatalf("Error while unmarshalling %s: %v", in, err)
+	}
+	if string(res.Info.Raw()) != "d1:ai1ee" {
+		t.Fatalf("expected info d1:ai1ee, got %s", res.Info.Raw())
+	}
+	b, err := Marshal(res)
+	if err != nil || string(b) != string(in) {
+		t.Fatalf("expected %s after round trip, got %s (%v)", in, b, err)
+	}
+}
+
+func TestDecoderAliasInput(t *testing.T) {
+	type TestStruct struct {
+		Info   Value      `bencode:"info"`
+		Pieces []byte     `bencode:"pieces"`
+		Raw    RawMessage `bencode:"raw"`
+	}
+	in := []byte("d4:infoi1e6:pieces4:abcd3:raw2:xye")
+
+	var copied, aliased TestStruct
+	if err := Unmarshal(in, &copied); err != nil {
+		t.Fatalf("Error while unmarshalling %s: %v", in, err)
+	}
+	dec := NewBytesDecoder(in)
+	dec.AliasInput()
+	if err := dec.Decode(&aliased); err != nil {
+		t.Fatalf("Error while decoding %s: %v", in, err)
+	}
+	if !reflect.DeepEqual(copied, aliased) {
+		t.Fatalf("expected %v, got %v", copied, aliased)
+	}
+
+	// overwrite the input, only the aliased values see the change
+	for i := range in {
+		in[i] = 'Z'
+	}
+	if string(copied.Pieces) != "abcd" || string(copied.Raw) != "2:xy" ||
+		string(copied.Info.Raw()) != "i1e" {
+		t.Fatalf("copied values changed with the input: %v", copied)
+	}
+	if string(aliased.Pieces) != "ZZZZ" || string(aliased.Raw) != "ZZZZ" ||
+		string(aliased.Info.Raw()) != "ZZZ" {
+		t.Fatalf("aliased values did not change with the input: %v", aliased)
+	}
+}
@@ -0,0 +1,58 @@
+package bitfield
+
+// Availability counts how many connected peers have each piece. Peers
+// are added with their bitfield when they connect, their have messages
+// as they come in, and removed with their bitfield when they go.
+type Availability struct {
+	counts []int
+}
+
+func NewAvailability(n int) *Availability {
+	return &Availability{counts: make([]int, n)}
+}
+
+// Count returns the number of peers that have piece i.
+func (a *Availability) Count(i int) int {
+	return a.counts[i]
+}
+
+// Add counts the pieces of a peer.
+func (a *Availability) Add(bf *Bitfield) {
+	a.checkLen(bf)
+	for i := bf.NextSet(0); i >= 0; i = bf.NextSet(i + 1) {
+		a.counts[i]++
+	}
+}
+
+// Remove stops counting the pieces of a peer.
+func (a *Availability) Remove(bf *Bitfield) {
+	a.checkLen(bf)
+	for i := bf.NextSet(0); i >= 0; i = bf.NextSet(i + 1) {
+		a.counts[i]--
+	}
+}
+
+// AddPiece counts a have message.
+func (a *Availability) AddPiece(i int) {
+	a.counts[i]++
+}
+
+// Rarest returns the piece of candidates the fewest peers have, but at
+// least one, the lowest index first on ties. It returns -1 if no peer has
+// any of candidates.
+func (a *Availability) Rarest(candidates *Bitfield) int {
+	a.checkLen(candidates)
+	best := -1
+	for i := candidates.NextSet(0); i >= 0; i = candidates.NextSet(i + 1) {
+		if c := a.counts[i]; c > 0 && (best < 0 || c < a.counts[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (a *Availability) checkLen(bf *Bitfield) {
+	if len(a.counts) != bf.n {
+		panic("bitfield: availability and bitfield lengths differ")
+	}
+}
@@ -0,0 +1,160 @@
+// Package bitfield keeps track of which pieces of a torrent we, or a peer,
+// have.
+package bitfield
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// A Bitfield is a set of piece indexes from 0 to Len()-1. Make one for a
+// torrent t with New(t.NumPieces()).
+type Bitfield struct {
+	n     int
+	words []uint64
+}
+
+func New(n int) *Bitfield {
+	if n < 0 {
+		panic(fmt.Sprintf("bitfield: negative length %d", n))
+	}
+	return &Bitfield{n: n, words: make([]uint64, (n+63)/64)}
+}
+
+// FromBytes decodes the wire format of a bitfield of n pieces, where the
+// high bit of the first byte is piece 0. The spare bits of the last byte
+// must be zero.
+func FromBytes(b []byte, n int) (*Bitfield, error) {
+	bf := New(n)
+	if len(b) != (n+7)/8 {
+		return nil, fmt.Errorf("bitfield of %d bytes for %d pieces", len(b), n)
+	}
+	if spare := len(b)*8 - n; spare > 0 && b[len(b)-1]&(1<<spare-1) != 0 {
+		return nil, errors.New("bitfield has spare bits set")
+	}
+	for i, c := range b {
+		// piece i*8 is the high bit of byte i and the low bit of its word
+		bf.words[i/8] |= uint64(bits.Reverse8(c)) << (i % 8 * 8)
+	}
+	return bf, nil
+}
+
+// Bytes encodes bf in the wire format, see FromBytes.
+func (bf *Bitfield) Bytes() []byte {
+	b := make([]byte, (bf.n+7)/8)
+	for i := range b {
+		b[i] = bits.Reverse8(byte(bf.words[i/8] >> (i % 8 * 8)))
+	}
+	return b
+}
+
+// Len returns the number of pieces bf has a bit for.
+func (bf *Bitfield) Len() int {
+	return bf.n
+}
+
+func (bf *Bitfield) Has(i int) bool {
+	bf.check(i)
+	return bf.words[i/64]&(1<<(i%64)) != 0
+}
+
+func (bf *Bitfield) Set(i int) {
+	bf.check(i)
+	bf.words[i/64] |= 1 << (i % 64)
+}
+
+func (bf *Bitfield) Clear(i int) {
+	bf.check(i)
+	bf.words[i/64] &^= 1 << (i % 64)
+}
+
+// Count returns the number of set bits.
+func (bf *Bitfield) Count() int {
+	n := 0
+	for _, w := range bf.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Full reports whether all bits are set.
+func (bf *Bitfield) Full() bool {
+	return bf.Count() == bf.n
+}
+
+func (bf *Bitfield) Copy() *Bitfield {
+	return &Bitfield{n: bf.n, words: append([]uint64(nil), bf.words...)}
+}
+
+// And returns the pieces in both bf and other.
+func (bf *Bitfield) And(other *Bitfield) *Bitfield {
+	bf.checkLen(other)
+	out := New(bf.n)
+	for i, w := range bf.words {
+		out.words[i] = w & other.words[i]
+	}
+	return out
+}
+
+// AndNot returns the pieces in bf but not in other, such as the pieces a
+// peer has that we don't with peer.AndNot(ours).
+func (bf *Bitfield) AndNot(other *Bitfield) *Bitfield {
+	bf.checkLen(other)
+	out := New(bf.n)
+	for i, w := range bf.words {
+		out.words[i] = w &^ other.words[i]
+	}
+	return out
+}
+
+// NextSet returns the first set bit at or after i, or -1 if there is none.
+// The set bits are iterated with
+//
+//	for i := bf.NextSet(0); i >= 0; i = bf.NextSet(i + 1) {
+func (bf *Bitfield) NextSet(i int) int {
+	return bf.next(i, 0)
+}
+
+// NextClear returns the first clear bit at or after i, or -1 if there is
+// none. It iterates like NextSet.
+func (bf *Bitfield) NextClear(i int) int {
+	return bf.next(i, ^uint64(0))
+}
+
+// next finds the first bit at or after i that is set once flipped with xor.
+func (bf *Bitfield) next(i int, xor uint64) int {
+	if i < 0 {
+		i = 0
+	}
+	for w := i / 64; w < len(bf.words); w++ {
+		word := bf.words[w] ^ xor
+		if w == i/64 {
+			word &= ^uint64(0) << (i % 64)
+		}
+		if word != 0 {
+			j := w*64 + bits.TrailingZeros64(word)
+			if j >= bf.n {
+				return -1
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+func (bf *Bitfield) String() string {
+	return fmt.Sprintf("%d/%d %x", bf.Count(), bf.n, bf.Bytes())
+}
+
+func (bf *Bitfield) check(i int) {
+	if i < 0 || i >= bf.n {
+		panic(fmt.Sprintf("bitfield: index %d out of range [0:%d]", i, bf.n))
+	}
+}
+
+func (bf *Bitfield) checkLen(other *Bitfield) {
+	if bf.n != other.n {
+		panic(fmt.Sprintf("bitfield: lengths %d and %d differ", bf.n, other.n))
+	}
+}
@@ -0,0 +1,177 @@
+package bitfield
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fromIndexes(n int, indexes ...int) *Bitfield {
+	bf := New(n)
+	for _, i := range indexes {
+		bf.Set(i)
+	}
+	return bf
+}
+
+func setBits(bf *Bitfield) []int {
+	var out []int
+	for i := bf.NextSet(0); i >= 0; i = bf.NextSet(i + 1) {
+		out = append(out, i)
+	}
+	return out
+}
+
+func clearBits(bf *Bitfield) []int {
+	var out []int
+	for i := bf.NextClear(0); i >= 0; i = bf.NextClear(i + 1) {
+		out = append(out, i)
+	}
+	return out
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	var testCases = []struct {
+		n       int
+		indexes []int
+		wire    string
+	}{
+		{0, nil, ""},
+		{1, []int{0}, "\x80"},
+		{8, []int{0, 7}, "\x81"},
+		{10, []int{1, 8, 9}, "\x40\xc0"},
+		{70, []int{0, 63, 64, 69}, "\x80\x00\x00\x00\x00\x00\x00\x01\x84"},
+	}
+	for _, tc := range testCases {
+		bf := fromIndexes(tc.n, tc.indexes...)
+		if b := bf.Bytes(); string(b) != tc.wire {
+			t.Fatalf("%v: wanted %q got %q", tc.indexes, tc.wire, b)
+		}
+		got, err := FromBytes([]byte(tc.wire), tc.n)
+		if err != nil {
+			t.Fatalf("%q: FromBytes failed: %v", tc.wire, err)
+		}
+		if !reflect.DeepEqual(setBits(got), tc.indexes) {
+			t.Fatalf("%q: wanted %v got %v", tc.wire, tc.indexes, setBits(got))
+		}
+	}
+}
+
+func TestFromBytesErrors(t *testing.T) {
+	var testCases = []struct {
+		wire        string
+		n           int
+		errContains string
+	}{
+		{"\x80", 9, "bitfield of 1 bytes for 9 pieces"},
+		{"\x80\x00", 8, "bitfield of 2 bytes for 8 pieces"},
+		{"\x01", 7, "spare bits set"},
+		{"\xff\xa0", 10, "spare bits set"},
+	}
+	for _, tc := range testCases {
+		_, err := FromBytes([]byte(tc.wire), tc.n)
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Fatalf("%q: expected error containing %q, got %v", tc.wire, tc.errContains, err)
+		}
+	}
+}
+
+func TestBitfieldOps(t *testing.T) {
+	a := fromIndexes(130, 0, 5, 64, 100, 129)
+	b := fromIndexes(130, 5, 6, 100, 128)
+	if c := a.Count(); c != 5 {
+		t.Fatalf("wanted count 5 got %d", c)
+	}
+	if !a.Has(64) || a.Has(65) {
+		t.Fatal("wrong Has")
+	}
+	if got := setBits(a.And(b)); !reflect.DeepEqual(got, []int{5, 100}) {
+		t.Fatalf("wrong And %v", got)
+	}
+	if got := setBits(a.AndNot(b)); !reflect.DeepEqual(got, []int{0, 64, 129}) {
+		t.Fatalf("wrong AndNot %v", got)
+	}
+	// the operands are left alone
+	if got := setBits(a); !reflect.DeepEqual(got, []int{0, 5, 64, 100, 129}) {
+		t.Fatalf("And changed its operand: %v", got)
+	}
+
+	c := a.Copy()
+	c.Clear(0)
+	if !a.Has(0) || c.Has(0) {
+		t.Fatal("Copy shares bits")
+	}
+
+	full := New(70)
+	for i := 0; i < 70; i++ {
+		full.Set(i)
+	}
+	if !full.Full() || New(70).Full() || !New(0).Full() {
+		t.Fatal("wrong Full")
+	}
+	if got := clearBits(full); got != nil {
+		t.Fatalf("clear bits in a full bitfield: %v", got)
+	}
+	full.Clear(3)
+	full.Clear(69)
+	if got := clearBits(full); !reflect.DeepEqual(got, []int{3, 69}) {
+		t.Fatalf("wrong clear bits %v", got)
+	}
+	if i := full.NextSet(70); i != -1 {
+		t.Fatalf("NextSet past the end returned %d", i)
+	}
+	if s := fromIndexes(10, 0, 9).String(); s != "2/10 8040" {
+		t.Fatalf("wrong String %q", s)
+	}
+}
+
+func TestBitfieldPanics(t *testing.T) {
+	var testCases = []struct {
+		name string
+		f    func()
+	}{
+		{"Has", func() { New(8).Has(8) }},
+		{"Set", func() { New(8).Set(-1) }},
+		{"And", func() { New(8).And(New(9)) }},
+		{"Rarest", func() { NewAvailability(8).Rarest(New(9)) }},
+	}
+	for _, tc := range testCases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: expected panic", tc.name)
+				}
+			}()
+			tc.f()
+		}()
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	a := NewAvailability(10)
+	p1 := fromIndexes(10, 0, 1, 2, 9)
+	p2 := fromIndexes(10, 1, 2)
+	p3 := fromIndexes(10, 2)
+	a.Add(p1)
+	a.Add(p2)
+	a.Add(p3)
+	a.AddPiece(5)
+	want := []int{1, 2, 3, 0, 0, 1, 0, 0, 0, 1}
+	for i, c := range want {
+		if a.Count(i) != c {
+			t.Fatalf("piece %d: wanted count %d got %d", i, c, a.Count(i))
+		}
+	}
+
+	if i := a.Rarest(fromIndexes(10, 1, 2, 3, 9)); i != 9 {
+		t.Fatalf("wanted rarest 9 got %d", i)
+	}
+	if i := a.Rarest(fromIndexes(10, 3, 4)); i != -1 {
+		t.Fatalf("wanted no rarest piece, got %d", i)
+	}
+
+	a.Remove(p1)
+	if i := a.Rarest(fromIndexes(10, 1, 2, 9)); i != 1 || a.Count(9) != 0 {
+		t.Fatalf("wanted rarest 1 after Remove, got %d", i)
+	}
+}
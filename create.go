@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filipochnik/btget/bencode"
+	"github.com/filipochnik/btget/torrent"
+)
+
+// listFlag collects the values of a flag that can be given several times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// create makes a torrent of a file or directory.
+func create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	fs.Usage = usage
+	var trackers, webSeeds listFlag
+	fs.Var(&trackers, "a", "tier of comma separated tracker URLs")
+	fs.Var(&webSeeds, "w", "web seed URL")
+	out := fs.String("o", "", "output file")
+	comment := fs.String("c", "", "comment")
+	pieceLength := fs.Int("l", 0, "piece length in bytes")
+	private := fs.Bool("p", false, "private torrent")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	opts := torrent.BuildOptions{
+		PieceLength:  *pieceLength,
+		Comment:      *comment,
+		CreatedBy:    "btget/" + version,
+		CreationDate: time.Now(),
+		Private:      *private,
+		WebSeeds:     webSeeds,
+	}
+	for _, tier := range trackers {
+		opts.AnnounceList = append(opts.AnnounceList, strings.Split(tier, ","))
+	}
+	m, err := torrent.Build(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	b, err := bencode.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = m.Info.Name + ".torrent"
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if err := ioutil.WriteFile(*out, b, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%s: info hash %x\n", *out, m.InfoHash)
+	return nil
+}
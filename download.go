@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/filipochnik/btget/engine"
+	"github.com/filipochnik/btget/torrent"
+	"github.com/filipochnik/btget/tracker"
+)
+
+// download downloads a torrent into the current directory, or the one
+// given with -o, announcing to its trackers for peers until it is done.
+func download(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fs.Usage = usage
+	dir := fs.String("o", ".", "download directory")
+	maxPeers := fs.Int("n", engine.DefaultMaxPeers, "number of peers")
+	verbose := fs.Bool("v", false, "print peer errors")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	metaInfo, err := loadMetaInfo(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var peerID [20]byte
+	copy(peerID[:], generatePeerID())
+
+	announcer := tracker.NewAnnouncer(tracker.NewTiers(metaInfo))
+	// ask the trackers for more peers when we run out
+	cfg := engine.Config{MaxPeers: *maxPeers, NeedPeers: announcer.Wake}
+	if *verbose {
+		cfg.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}
+	}
+	e, err := engine.New(metaInfo, *dir, peerID, cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	announceReq := func() torrent.AnnounceRequest {
+		stats := e.Stats()
+		req := torrent.AnnounceRequest{
+			PeerID:     peerID,
+			Port:       6889,
+			Downloaded: int(stats.Downloaded),
+			Left:       int(stats.Left),
+			NumWant:    *maxPeers,
+		}
+		copy(req.InfoHash[:], metaInfo.InfoHash)
+		return req
+	}
+	announceCtx, stopAnnouncing := context.WithCancel(ctx)
+	announced := make(chan struct{})
+	go func() {
+		announcer.Run(announceCtx, announceReq, func(resp *torrent.AnnounceResponse, err error) {
+			if err != nil {
+				fmt.Println("announce failed:", err)
+				return
+			}
+			if resp.WarningMessage != "" {
+				fmt.Println("tracker warning:", resp.WarningMessage)
+			}
+			e.AddPeers(resp.Peers)
+		})
+		close(announced)
+	}()
+	defer func() {
+		// Run sends the stopped event before it returns
+		stopAnnouncing()
+		<-announced
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Println(e.Stats())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = e.Run(ctx)
+	close(done)
+	if err != nil {
+		return err
+	}
+	fmt.Println(e.Stats())
+	req := announceReq()
+	req.Event = torrent.EventCompleted
+	if _, err := announcer.Announce(ctx, req); err != nil {
+		fmt.Println("announce failed:", err)
+	}
+	return nil
+}
+
+func generatePeerID() []byte {
+	prefix := []byte(fmt.Sprintf("-GT%s-", version))
+	suffix := make([]byte, 20-len(prefix))
+	for i := range suffix {
+		// skips whitespace and non-printable characters
+		suffix[i] = byte(rune(rand.Intn(127-33) + 33))
+	}
+	return append(prefix, suffix...)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+// dump prints a bencode file as JSON, or with -r converts JSON back into
+// the bencode it came from. FILE "-" is standard input.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Usage = usage
+	reverse := fs.Bool("r", false, "convert JSON to bencode")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	var data []byte
+	var err error
+	if name := fs.Arg(0); name == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *reverse {
+		b, err := bencode.FromJSON(data)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return printBencode(data)
+}
+
+// printBencode prints data as indented JSON, see bencode.ToJSON. If the
+// JSON doesn't convert back to data exactly, a warning says so.
+func printBencode(data []byte) error {
+	j, err := bencode.ToJSON(data)
+	if err == bencode.ErrLossyJSON {
+		fmt.Fprintf(os.Stderr, "btget: warning: %v, "+
+			"converting the JSON back will not give the same bytes\n", err)
+	} else if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, j, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
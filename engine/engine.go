@@ -0,0 +1,244 @@
+// Package engine downloads a torrent from many peers at once.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+// Config tunes an Engine. Zero fields take the default values below.
+type Config struct {
+	// MaxPeers is how many peers are downloaded from at the same time.
+	MaxPeers int
+	// Pipeline is how many block requests are kept outstanding per peer.
+	Pipeline int
+	// PeerTimeout is how long a peer can go without giving us a block
+	// before it is replaced.
+	PeerTimeout time.Duration
+	// RequestTimeout is how long a block request can go unanswered before
+	// the block is requested from other peers.
+	RequestTimeout time.Duration
+	// MinRate is the fewest bytes a second a peer has to send, over
+	// periods of PeerTimeout while it has requests to serve, not to be
+	// replaced.
+	MinRate     int
+	DialTimeout time.Duration
+	// NeedPeers, if set, is called when there are no more peers to connect
+	// to and fewer than MaxPeers are connected, typically to announce to
+	// the tracker early.
+	NeedPeers func()
+	// Logf, if set, is given messages about peers and pieces.
+	Logf func(format string, args ...interface{})
+}
+
+const (
+	DefaultMaxPeers       = 30
+	DefaultPipeline       = 16
+	DefaultPeerTimeout    = time.Minute
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultMinRate        = 1024
+	DefaultDialTimeout    = 10 * time.Second
+)
+
+// An Engine downloads a torrent into the files of a FileLayout. It is
+// given peers with AddPeers, typically from a tracker, and connects to up
+// to Config.MaxPeers of them. Peers that fail or are too slow are dropped
+// and replaced with others that were added. Dropped peers are forgotten,
+// so adding them again gives them another chance, except for those that
+// sent corrupt data. When there are no peers left to try, more are
+// asked for with Config.NeedPeers.
+type Engine struct {
+	t        *torrent.Torrent
+	infoHash [20]byte
+	peerID   [20]byte
+	cfg      Config
+	storage  *storage
+	picker   *picker
+
+	mu         sync.Mutex
+	candidates []torrent.Peer
+	known      map[string]bool // candidates, connected and banned peers
+	connected  int
+	downloaded int64 // bytes of all blocks received
+	// wake tells Run that there are new candidates or fewer connections
+	wake chan struct{}
+}
+
+// New creates the files of the torrent in m under dir, see
+// torrent.NewFileLayout, and returns an Engine that downloads into them.
+func New(m *torrent.MetaInfo, dir string, peerID [20]byte, cfg Config) (*Engine, error) {
+	layout, err := torrent.NewFileLayout(&m.Info, dir)
+	if err != nil {
+		return nil, err
+	}
+	t := torrent.NewTorrent(*m)
+	s, err := openStorage(t, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxPeers <= 0 {
+		cfg.MaxPeers = DefaultMaxPeers
+	}
+	if cfg.Pipeline <= 0 {
+		cfg.Pipeline = DefaultPipeline
+	}
+	if cfg.PeerTimeout <= 0 {
+		cfg.PeerTimeout = DefaultPeerTimeout
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	if cfg.MinRate <= 0 {
+		cfg.MinRate = DefaultMinRate
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	e := &Engine{
+		t:       t,
+		peerID:  peerID,
+		cfg:     cfg,
+		storage: s,
+		picker:  newPicker(t, s),
+		known:   make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+	copy(e.infoHash[:], m.InfoHash)
+	return e, nil
+}
+
+// AddPeers adds peers to connect to, ignoring those already known.
+func (e *Engine) AddPeers(peers torrent.Peers) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, p := range peers {
+		if addr := p.Addr(); !e.known[addr] {
+			e.known[addr] = true
+			e.candidates = append(e.candidates, p)
+		}
+	}
+	e.wakeUp()
+}
+
+func (e *Engine) wakeUp() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run downloads until all pieces are written, which returns nil, ctx is
+// done, or a piece can't be written. The files are closed when it returns.
+func (e *Engine) Run(ctx context.Context) error {
+	defer e.storage.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for {
+		for {
+			p, ok := e.nextPeer()
+			if !ok {
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := newPeerConnection(e, p).Run(ctx)
+				if ctx.Err() == nil {
+					e.logf("peer %s: %v", p.Addr(), err)
+				}
+				e.peerDone(p.Addr(), err)
+			}()
+		}
+		if e.cfg.NeedPeers != nil && e.needPeers() {
+			e.cfg.NeedPeers()
+		}
+
+		select {
+		case <-e.picker.done:
+			return e.picker.result()
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.wake:
+		}
+	}
+}
+
+// peerDone frees the slot of a peer whose connection ended with err.
+func (e *Engine) peerDone(addr string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connected--
+	if !errors.Is(err, errCorruptPiece) {
+		delete(e.known, addr)
+	}
+	e.wakeUp()
+}
+
+// nextPeer takes a candidate to connect to, if there is one and fewer than
+// Config.MaxPeers are connected.
+func (e *Engine) nextPeer() (torrent.Peer, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.candidates) == 0 || e.connected >= e.cfg.MaxPeers {
+		return torrent.Peer{}, false
+	}
+	p := e.candidates[0]
+	e.candidates = e.candidates[1:]
+	e.connected++
+	return p, true
+}
+
+// needPeers reports whether there is room for connections but no
+// candidates left.
+func (e *Engine) needPeers() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.candidates) == 0 && e.connected < e.cfg.MaxPeers
+}
+
+// Stats are the progress of a download.
+type Stats struct {
+	Peers      int   // connections, including ones being made
+	Pieces     int   // pieces written
+	Downloaded int64 // bytes received, including wasted ones
+	Left       int64 // bytes of pieces not written yet
+}
+
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	s := Stats{Peers: e.connected, Downloaded: e.downloaded}
+	e.mu.Unlock()
+
+	e.picker.mu.Lock()
+	defer e.picker.mu.Unlock()
+	s.Pieces = e.picker.have.Count()
+	s.Left = int64(e.t.Length) - e.picker.downloaded
+	return s
+}
+
+func (e *Engine) addDownloaded(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.downloaded += int64(n)
+}
+
+func (e *Engine) logf(format string, args ...interface{}) {
+	if e.cfg.Logf != nil {
+		e.cfg.Logf(format, args...)
+	}
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d peers, %d pieces, %d bytes left", s.Peers, s.Pieces, s.Left)
+}
@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filipochnik/btget/bitfield"
+	"github.com/filipochnik/btget/peerwire"
+	"github.com/filipochnik/btget/torrent"
+)
+
+// testTorrent builds a torrent of files with the given lengths and random
+// content, and returns it with the content of each file.
+func testTorrent(t *testing.T, pieceLength int, lengths ...int) (*torrent.MetaInfo, [][]byte) {
+	dir := filepath.Join(t.TempDir(), "content")
+	r := rand.New(rand.NewSource(1))
+	var files [][]byte
+	for i, n := range lengths {
+		data := make([]byte, n)
+		r.Read(data)
+		files = append(files, data)
+		path := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := torrent.Build(dir, torrent.BuildOptions{PieceLength: pieceLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, files
+}
+
+// seeder is a stand-in peer that serves the pieces in has.
+type seeder struct {
+	m    *torrent.MetaInfo
+	data []byte // the content of the torrent
+	has  *bitfield.Bitfield
+
+	silent  bool          // send nothing after the handshake
+	corrupt bool          // send blocks with a byte flipped
+	delay   time.Duration // wait before sending each block
+
+	mu     sync.Mutex
+	served int // blocks sent
+}
+
+func newSeeder(m *torrent.MetaInfo, files [][]byte) *seeder {
+	s := &seeder{m: m, data: bytes.Join(files, nil)}
+	s.has = bitfield.New(torrent.NewTorrent(*m).NumPieces())
+	for i := 0; i < s.has.Len(); i++ {
+		s.has.Set(i)
+	}
+	return s
+}
+
+func (s *seeder) start(t *testing.T) torrent.Peer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return torrent.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func (s *seeder) serve(conn net.Conn) {
+	defer conn.Close()
+	var infoHash [20]byte
+	copy(infoHash[:], s.m.InfoHash)
+	if _, err := peerwire.ReadHandshake(conn, infoHash); err != nil {
+		return
+	}
+	hs := &peerwire.Handshake{InfoHash: infoHash}
+	copy(hs.PeerID[:], "-XX0000-seederseeder")
+	if err := peerwire.WriteHandshake(conn, hs); err != nil {
+		return
+	}
+	if s.silent {
+		io.Copy(ioutil.Discard, conn)
+		return
+	}
+
+	w := peerwire.NewWriter(conn)
+	w.WriteMessage(peerwire.Bitfield{Bits: s.has.Bytes()})
+	w.WriteMessage(peerwire.Unchoke{})
+	r := peerwire.NewReader(conn)
+	for {
+		m, err := r.ReadMessage()
+		if err != nil {
+			return
+		}
+		req, ok := m.(peerwire.Request)
+		if !ok {
+			continue
+		}
+		off := int(req.Index)*s.m.Info.PieceLength + int(req.Begin)
+		b := append([]byte(nil), s.data[off:off+int(req.Length)]...)
+		if s.corrupt {
+			b[0] ^= 0xff
+		}
+		time.Sleep(s.delay)
+		s.mu.Lock()
+		s.served++
+		s.mu.Unlock()
+		if err := w.WriteMessage(peerwire.Piece{Index: req.Index, Begin: req.Begin, Block: b}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *seeder) blocks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.served
+}
+
+// deadPeer returns a peer that refuses connections.
+func deadPeer(t *testing.T) torrent.Peer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+	return torrent.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func runEngine(t *testing.T, m *torrent.MetaInfo, cfg Config, peers ...torrent.Peer) string {
+	dir := t.TempDir()
+	var peerID [20]byte
+	copy(peerID[:], "-GT0001-abcdefghijkl")
+	cfg.Logf = t.Logf
+	e, err := New(m, dir, peerID, cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.AddPeers(peers)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Run(ctx); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if s := e.Stats(); s.Left != 0 || s.Pieces != torrent.NewTorrent(*m).NumPieces() {
+		t.Fatalf("wrong stats after the download: %v", s)
+	}
+	return dir
+}
+
+func checkFiles(t *testing.T, dir string, m *torrent.MetaInfo, files [][]byte) {
+	for i, want := range files {
+		path := filepath.Join(dir, m.Info.Name, string(rune('a'+i)))
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s differs from the original", path)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	// pieces of 2.5 blocks, across files, one of them empty
+	m, files := testTorrent(t, 40*1024, 100000, 0, 50000, 1)
+	s1, s2 := newSeeder(m, files), newSeeder(m, files)
+	dir := runEngine(t, m, Config{Pipeline: 4}, s1.start(t), s2.start(t))
+	checkFiles(t, dir, m, files)
+	if s1.blocks() == 0 || s2.blocks() == 0 {
+		t.Fatalf("wanted blocks from both peers, got %d and %d", s1.blocks(), s2.blocks())
+	}
+	// three pieces of three blocks, and the last of two
+	if s1.blocks()+s2.blocks() != 11 {
+		t.Fatalf("wanted 11 blocks, got %d", s1.blocks()+s2.blocks())
+	}
+}
+
+func TestDownloadReplacesPeers(t *testing.T) {
+	m, files := testTorrent(t, 32*1024, 200000)
+	silent := newSeeder(m, files)
+	silent.silent = true
+	corrupt := newSeeder(m, files)
+	corrupt.corrupt = true
+	good := newSeeder(m, files)
+
+	// with a single connection at a time the dead and silent peers are
+	// replaced by the corrupt one, and that by the good one
+	cfg := Config{MaxPeers: 1, Pipeline: 2, PeerTimeout: 100 * time.Millisecond}
+	dir := runEngine(t, m, cfg, deadPeer(t), silent.start(t), corrupt.start(t), good.start(t))
+	checkFiles(t, dir, m, files)
+	// the first piece, and what was requested before it was complete
+	if corrupt.blocks() > 4 {
+		t.Fatalf("wanted one piece from the corrupt peer, got %d blocks", corrupt.blocks())
+	}
+}
+
+func TestDownloadMixedCorruption(t *testing.T) {
+	m, files := testTorrent(t, 64*1024, 300000)
+	corrupt := newSeeder(m, files)
+	corrupt.corrupt = true
+	good := newSeeder(m, files)
+	// pieces with blocks from both peers fail, and are downloaded again
+	// from one of them until the corrupt one is found out
+	dir := runEngine(t, m, Config{Pipeline: 2}, corrupt.start(t), good.start(t))
+	checkFiles(t, dir, m, files)
+}
+
+func TestDownloadCanceled(t *testing.T) {
+	m, files := testTorrent(t, 16*1024, 50000)
+	silent := newSeeder(m, files)
+	silent.silent = true
+	var peerID [20]byte
+	e, err := New(m, t.TempDir(), peerID, Config{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.AddPeers(torrent.Peers{silent.start(t)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if s := e.Stats(); s.Peers != 0 || s.Pieces != 0 {
+		t.Fatalf("wrong stats after cancel: %v", s)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	m, _ := testTorrent(t, 16*1024, 10)
+	m.Info.Name = ".."
+	var peerID [20]byte
+	if _, err := New(m, t.TempDir(), peerID, Config{}); err == nil || !strings.Contains(err.Error(), "invalid name") {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+// fastTicks makes peers check their timeouts often for the duration of a
+// test.
+func fastTicks(t *testing.T) {
+	interval := tickInterval
+	tickInterval = 10 * time.Millisecond
+	t.Cleanup(func() { tickInterval = interval })
+}
+
+func TestDownloadSlowPeers(t *testing.T) {
+	fastTicks(t)
+	m, files := testTorrent(t, 32*1024, 200000)
+
+	// the blocks requested from a peer that doesn't send them are
+	// requested from the other one
+	stuck := newSeeder(m, files)
+	stuck.delay = 5 * time.Second
+	good := newSeeder(m, files)
+	start := time.Now()
+	cfg := Config{Pipeline: 2, RequestTimeout: 50 * time.Millisecond}
+	dir := runEngine(t, m, cfg, stuck.start(t), good.start(t))
+	checkFiles(t, dir, m, files)
+	if d := time.Since(start); d > stuck.delay {
+		t.Fatalf("download waited for the stuck peer, took %v", d)
+	}
+
+	// a peer that sends too little is replaced, even though it never
+	// goes long without sending a block
+	trickle := newSeeder(m, files)
+	trickle.delay = 20 * time.Millisecond
+	good = newSeeder(m, files)
+	cfg = Config{MaxPeers: 1, PeerTimeout: 200 * time.Millisecond, MinRate: 4 << 20}
+	dir = runEngine(t, m, cfg, trickle.start(t), good.start(t))
+	checkFiles(t, dir, m, files)
+	if trickle.blocks() == 0 || good.blocks() == 0 {
+		t.Fatalf("wanted blocks from both peers, got %d and %d", trickle.blocks(), good.blocks())
+	}
+}
+
+func TestDownloadNeedPeers(t *testing.T) {
+	m, files := testTorrent(t, 16*1024, 50000)
+	s := newSeeder(m, files)
+	peer := s.start(t)
+	var peerID [20]byte
+	var e *Engine
+	calls := 0
+	cfg := Config{NeedPeers: func() {
+		// called by Run, which has no lock held
+		calls++
+		if calls == 1 {
+			e.AddPeers(torrent.Peers{peer})
+		}
+	}}
+	e, err := New(m, t.TempDir(), peerID, cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Run(ctx); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if calls == 0 || s.blocks() == 0 {
+		t.Fatalf("wanted peers to be asked for, got %d calls and %d blocks", calls, s.blocks())
+	}
+}
@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/filipochnik/btget/bitfield"
+	"github.com/filipochnik/btget/peerwire"
+	"github.com/filipochnik/btget/torrent"
+)
+
+const (
+	handshakeTimeout  = 10 * time.Second
+	keepAliveInterval = 2 * time.Minute
+)
+
+// tickInterval is how often timeouts and the rate of a peer are checked,
+// a variable so that tests don't have to wait for as long.
+var tickInterval = time.Second
+
+// A PeerConnection downloads from a single peer. A reader and a writer
+// goroutine move messages between the connection and the goroutine
+// running Run, which keeps up to Config.Pipeline block requests
+// outstanding while the peer isn't choking us. Requests that aren't served
+// within Config.RequestTimeout are given to other peers, and a peer that
+// sends less than Config.MinRate while it has requests to serve is
+// dropped. We only download, so the peer is never unchoked.
+type PeerConnection struct {
+	Peer torrent.Peer
+
+	AmChoking      bool
+	AmInterested   bool
+	PeerChoking    bool
+	PeerInterested bool
+
+	e        *Engine
+	addr     string
+	conn     net.Conn
+	has      *bitfield.Bitfield // the peer's pieces
+	sentHave *bitfield.Bitfield // our pieces the peer was told about
+	requests map[block]request
+	out      chan peerwire.Message
+	// writerDone is closed when the writer stops
+	writerDone chan struct{}
+	lastSent   time.Time
+	// lastBlock is when the last block arrived, or the connection was
+	// made
+	lastBlock time.Time
+	// rateStart is when the period the rate of the peer is measured over
+	// started, rateBytes what the peer sent since
+	rateStart time.Time
+	rateBytes int
+}
+
+// A request is a block requested from the peer.
+type request struct {
+	sent     time.Time
+	timedOut bool // the block may be requested from other peers
+}
+
+func newPeerConnection(e *Engine, peer torrent.Peer) *PeerConnection {
+	return &PeerConnection{
+		Peer:        peer,
+		AmChoking:   true,
+		PeerChoking: true,
+		e:           e,
+		addr:        peer.Addr(),
+		has:         bitfield.New(e.t.NumPieces()),
+		requests:    make(map[block]request),
+		out:         make(chan peerwire.Message, 16),
+		writerDone:  make(chan struct{}),
+	}
+}
+
+// Run connects to the peer and downloads from it until ctx is done, the
+// connection fails, or the peer gave us no block for Config.PeerTimeout.
+func (pc *PeerConnection) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d := net.Dialer{Timeout: pc.e.cfg.DialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", pc.addr)
+	if err != nil {
+		return err
+	}
+	pc.conn = conn
+	defer conn.Close()
+	// closing the connection stops the reader and writer
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := pc.handshake(); err != nil {
+		return fmt.Errorf("handshake: %v", err)
+	}
+	pc.e.picker.addPeer(pc.has)
+	defer func() {
+		pc.e.picker.removePeer(pc.has)
+		pc.cancelRequests()
+		pc.e.picker.release(pc.addr)
+	}()
+
+	in := make(chan peerwire.Message)
+	errc := make(chan error, 2)
+	go func() {
+		r := peerwire.NewReader(conn)
+		for {
+			m, err := r.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case in <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(pc.writerDone)
+		w := peerwire.NewWriter(conn)
+		for {
+			select {
+			case m := <-pc.out:
+				if err := w.WriteMessage(m); err != nil {
+					errc <- err
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	pc.lastBlock = time.Now()
+	pc.rateStart = pc.lastBlock
+	pc.sentHave = pc.e.picker.haveCopy()
+	if pc.sentHave.Count() > 0 {
+		pc.send(peerwire.Bitfield{Bits: pc.sentHave.Bytes()})
+	}
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return err
+		case m := <-in:
+			if err := pc.handle(m); err != nil {
+				return err
+			}
+		case now := <-ticker.C:
+			if now.Sub(pc.lastBlock) > pc.e.cfg.PeerTimeout {
+				return fmt.Errorf("no block for %v", pc.e.cfg.PeerTimeout)
+			}
+			if now.Sub(pc.lastSent) > keepAliveInterval {
+				pc.send(peerwire.KeepAlive{})
+			}
+			if err := pc.checkRate(now); err != nil {
+				return err
+			}
+			pc.expireRequests(now)
+		}
+		pc.sendHaves()
+		pc.updateInterest()
+		pc.fillPipeline()
+	}
+}
+
+func (pc *PeerConnection) handshake() error {
+	pc.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer pc.conn.SetDeadline(time.Time{})
+	hs := &peerwire.Handshake{InfoHash: pc.e.infoHash, PeerID: pc.e.peerID}
+	if err := peerwire.WriteHandshake(pc.conn, hs); err != nil {
+		return err
+	}
+	peerHS, err := peerwire.ReadHandshake(pc.conn, pc.e.infoHash)
+	if err != nil {
+		return err
+	}
+	pc.Peer.ID = peerHS.PeerID[:]
+	return nil
+}
+
+// send queues m for the writer. Once the writer has stopped m is dropped,
+// Run returns the writer's error next.
+func (pc *PeerConnection) send(m peerwire.Message) {
+	select {
+	case pc.out <- m:
+		pc.lastSent = time.Now()
+	case <-pc.writerDone:
+	}
+}
+
+func (pc *PeerConnection) handle(m peerwire.Message) error {
+	switch m := m.(type) {
+	case peerwire.Choke:
+		pc.PeerChoking = true
+		// the peer drops the requests it hasn't served
+		pc.cancelRequests()
+		clear(pc.requests)
+	case peerwire.Unchoke:
+		pc.PeerChoking = false
+	case peerwire.Interested:
+		pc.PeerInterested = true
+	case peerwire.NotInterested:
+		pc.PeerInterested = false
+	case peerwire.Have:
+		i := int(m.Index)
+		if i >= pc.has.Len() {
+			return fmt.Errorf("have message for piece %d of %d", i, pc.has.Len())
+		}
+		if !pc.has.Has(i) {
+			pc.has.Set(i)
+			pc.e.picker.peerHave(i)
+		}
+	case peerwire.Bitfield:
+		has, err := bitfield.FromBytes(m.Bits, pc.has.Len())
+		if err != nil {
+			return err
+		}
+		pc.e.picker.removePeer(pc.has)
+		pc.has = has
+		pc.e.picker.addPeer(pc.has)
+	case peerwire.Piece:
+		b := block{piece: int(m.Index), begin: int(m.Begin), length: len(m.Block)}
+		if _, ok := pc.requests[b]; !ok {
+			// not requested, or canceled by a choke
+			return nil
+		}
+		delete(pc.requests, b)
+		pc.lastBlock = time.Now()
+		pc.rateBytes += b.length
+		pc.e.addDownloaded(b.length)
+		if err := pc.e.picker.received(pc.addr, b, m.Block); err != nil {
+			return fmt.Errorf("piece %d: %w", b.piece, err)
+		}
+	case peerwire.Request, peerwire.Cancel, peerwire.Port, peerwire.KeepAlive, peerwire.Unknown:
+		// we don't upload and don't run a DHT node
+	default:
+		return errors.New("unexpected message")
+	}
+	return nil
+}
+
+// sendHaves tells the peer about the pieces we got since the last call.
+func (pc *PeerConnection) sendHaves() {
+	have := pc.e.picker.haveCopy()
+	news := have.AndNot(pc.sentHave)
+	for i := news.NextSet(0); i >= 0; i = news.NextSet(i + 1) {
+		pc.send(peerwire.Have{Index: uint32(i)})
+	}
+	pc.sentHave = have
+}
+
+func (pc *PeerConnection) updateInterest() {
+	wants := pc.e.picker.wants(pc.has)
+	if wants == pc.AmInterested {
+		return
+	}
+	pc.AmInterested = wants
+	if wants {
+		pc.send(peerwire.Interested{})
+	} else {
+		pc.send(peerwire.NotInterested{})
+	}
+}
+
+// checkRate returns an error if the peer sent less than Config.MinRate over
+// the last Config.PeerTimeout. Time when it had no requests to serve isn't
+// counted.
+func (pc *PeerConnection) checkRate(now time.Time) error {
+	if pc.PeerChoking || len(pc.requests) == 0 {
+		pc.rateStart, pc.rateBytes = now, 0
+		return nil
+	}
+	d := now.Sub(pc.rateStart)
+	if d < pc.e.cfg.PeerTimeout {
+		return nil
+	}
+	if rate := float64(pc.rateBytes) / d.Seconds(); rate < float64(pc.e.cfg.MinRate) {
+		return fmt.Errorf("sent %.0f bytes/s, less than %d", rate, pc.e.cfg.MinRate)
+	}
+	pc.rateStart, pc.rateBytes = now, 0
+	return nil
+}
+
+// expireRequests lets other peers request the blocks of requests older
+// than Config.RequestTimeout. They are kept in case the peer sends them
+// after all, and count towards the pipeline, so a slow peer asks for less.
+func (pc *PeerConnection) expireRequests(now time.Time) {
+	for b, r := range pc.requests {
+		if !r.timedOut && now.Sub(r.sent) > pc.e.cfg.RequestTimeout {
+			pc.e.picker.timeout(pc.addr, b)
+			pc.requests[b] = request{sent: r.sent, timedOut: true}
+		}
+	}
+}
+
+// cancelRequests returns the blocks of outstanding requests to the picker.
+func (pc *PeerConnection) cancelRequests() {
+	for b, r := range pc.requests {
+		if !r.timedOut {
+			pc.e.picker.cancel(b)
+		}
+	}
+}
+
+func (pc *PeerConnection) fillPipeline() {
+	for !pc.PeerChoking && pc.AmInterested && len(pc.requests) < pc.e.cfg.Pipeline {
+		b, ok := pc.e.picker.pick(pc.addr, pc.has)
+		if !ok {
+			return
+		}
+		pc.requests[b] = request{sent: time.Now()}
+		pc.send(peerwire.Request{Index: uint32(b.piece), Begin: uint32(b.begin), Length: uint32(b.length)})
+	}
+}
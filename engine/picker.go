@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"sync"
+
+	"github.com/filipochnik/btget/bitfield"
+	"github.com/filipochnik/btget/torrent"
+)
+
+// BlockSize is the size of the blocks pieces are requested in.
+const BlockSize = 16 * 1024
+
+// errCorruptPiece is returned for a piece that failed the hash check with
+// all its blocks from the same peer.
+var errCorruptPiece = errors.New("sent a corrupt piece")
+
+// A block is a part of a piece that is requested from a peer.
+type block struct {
+	piece, begin, length int
+}
+
+// partialPiece is a piece that is being downloaded.
+type partialPiece struct {
+	data      []byte
+	requested []int // how many peers each block is requested from
+	got       []bool
+	missing   int      // blocks not received yet
+	from      []string // the address of the peer each block came from
+	// timedOut is the address of the last peer a request for each block
+	// timed out at. The request still stands, so the block isn't given to
+	// that peer again.
+	timedOut []string
+	// owner, if set, is the only peer the piece is requested from
+	owner string
+}
+
+// picker decides which blocks peers are asked for and collects them into
+// pieces. Pieces are started rarest first, and the blocks of started
+// pieces are handed out before new pieces are started. It is shared by
+// all peers, which are told apart by their addresses.
+//
+// A piece that fails the hash check with blocks from several peers is
+// downloaded again from a single one, so that a peer sending bad data is
+// found out the next time.
+type picker struct {
+	t       *torrent.Torrent
+	storage *storage
+
+	mu           sync.Mutex
+	have         *bitfield.Bitfield
+	availability *bitfield.Availability
+	partial      map[int]*partialPiece
+	writing      map[int]bool // verified pieces being written out
+	retry        map[int]bool // pieces to download from a single peer
+	downloaded   int64        // bytes of verified pieces
+	err          error
+	done         chan struct{} // closed when all pieces are written or on err
+}
+
+func newPicker(t *torrent.Torrent, s *storage) *picker {
+	n := t.NumPieces()
+	p := &picker{
+		t:            t,
+		storage:      s,
+		have:         bitfield.New(n),
+		availability: bitfield.NewAvailability(n),
+		partial:      make(map[int]*partialPiece),
+		writing:      make(map[int]bool),
+		retry:        make(map[int]bool),
+		done:         make(chan struct{}),
+	}
+	if n == 0 {
+		close(p.done)
+	}
+	return p
+}
+
+// pick returns a block of a piece in peerHas for the peer at addr to
+// request, and false if there is none left that isn't already requested.
+func (p *picker) pick(addr string, peerHas *bitfield.Bitfield) (block, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// finish the pieces that are started first, the lowest index first
+	// so that peers work on the same pieces
+	candidates := peerHas.AndNot(p.have)
+	for i := candidates.NextSet(0); i >= 0; i = candidates.NextSet(i + 1) {
+		pp, ok := p.partial[i]
+		if !ok {
+			continue
+		}
+		candidates.Clear(i)
+		if pp.owner != "" && pp.owner != addr {
+			continue
+		}
+		for j := range pp.requested {
+			if pp.requested[j] == 0 && !pp.got[j] && pp.timedOut[j] != addr {
+				pp.requested[j]++
+				return p.block(i, j), true
+			}
+		}
+	}
+	for i := range p.writing {
+		candidates.Clear(i)
+	}
+
+	i := p.availability.Rarest(candidates)
+	if i < 0 {
+		return block{}, false
+	}
+	n := (p.t.PieceLength(i) + BlockSize - 1) / BlockSize
+	pp := &partialPiece{
+		data:      make([]byte, p.t.PieceLength(i)),
+		requested: make([]int, n),
+		got:       make([]bool, n),
+		missing:   n,
+		from:      make([]string, n),
+		timedOut:  make([]string, n),
+	}
+	if p.retry[i] {
+		pp.owner = addr
+	}
+	p.partial[i] = pp
+	pp.requested[0]++
+	return p.block(i, 0), true
+}
+
+func (p *picker) block(piece, j int) block {
+	b := block{piece: piece, begin: j * BlockSize, length: BlockSize}
+	if rest := p.t.PieceLength(piece) - b.begin; rest < b.length {
+		b.length = rest
+	}
+	return b
+}
+
+// cancel returns a block that was picked but won't arrive to the picker.
+func (p *picker) cancel(b block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pp, ok := p.partial[b.piece]; ok && pp.requested[b.begin/BlockSize] > 0 {
+		pp.requested[b.begin/BlockSize]--
+	}
+}
+
+// timeout makes a block the peer at addr didn't send in time available
+// to other peers. The peer may still send it, but the request no longer
+// counts, and shouldn't be canceled.
+func (p *picker) timeout(addr string, b block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pp, ok := p.partial[b.piece]; ok {
+		j := b.begin / BlockSize
+		if pp.requested[j] > 0 {
+			pp.requested[j]--
+		}
+		pp.timedOut[j] = addr
+	}
+}
+
+// release forgets the pieces owned by the peer at addr, which is gone.
+func (p *picker) release(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, pp := range p.partial {
+		if pp.owner == addr {
+			delete(p.partial, i)
+		}
+	}
+}
+
+// received adds a block that arrived from the peer at addr. When it
+// completes a piece, the piece is checked and written out. A piece that
+// fails the check is downloaded again, and if all of it came from addr
+// errCorruptPiece is returned.
+func (p *picker) received(addr string, b block, data []byte) error {
+	p.mu.Lock()
+	pp, ok := p.partial[b.piece]
+	j := b.begin / BlockSize
+	if !ok || pp.got[j] {
+		// a duplicate
+		p.mu.Unlock()
+		return nil
+	}
+	copy(pp.data[b.begin:], data)
+	pp.got[j] = true
+	pp.from[j] = addr
+	pp.missing--
+	if pp.missing > 0 {
+		p.mu.Unlock()
+		return nil
+	}
+
+	delete(p.partial, b.piece)
+	sum := sha1.Sum(pp.data)
+	if !bytes.Equal(sum[:], p.t.PieceHash(b.piece)) {
+		defer p.mu.Unlock()
+		for _, from := range pp.from {
+			if from != addr {
+				p.retry[b.piece] = true
+				return nil
+			}
+		}
+		return errCorruptPiece
+	}
+	delete(p.retry, b.piece)
+	p.writing[b.piece] = true
+	p.mu.Unlock()
+
+	// other peers carry on while the piece is written
+	err := p.storage.writePiece(b.piece, pp.data)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.writing, b.piece)
+	if err != nil {
+		p.finish(err)
+		return nil
+	}
+	p.have.Set(b.piece)
+	p.downloaded += int64(len(pp.data))
+	if p.have.Full() {
+		p.finish(nil)
+	}
+	return nil
+}
+
+// finish ends the download with err, or as complete if err is nil. The
+// caller holds p.mu.
+func (p *picker) finish(err error) {
+	select {
+	case <-p.done:
+	default:
+		p.err = err
+		close(p.done)
+	}
+}
+
+// result is the error the download ended with, nil if it is complete.
+func (p *picker) result() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// wants reports whether peerHas has pieces we don't.
+func (p *picker) wants(peerHas *bitfield.Bitfield) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return peerHas.AndNot(p.have).Count() > 0
+}
+
+// haveCopy returns a copy of the pieces that are written out.
+func (p *picker) haveCopy() *bitfield.Bitfield {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.have.Copy()
+}
+
+func (p *picker) addPeer(bf *bitfield.Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.availability.Add(bf)
+}
+
+func (p *picker) removePeer(bf *bitfield.Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.availability.Remove(bf)
+}
+
+func (p *picker) peerHave(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.availability.AddPiece(i)
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/filipochnik/btget/bitfield"
+	"github.com/filipochnik/btget/torrent"
+)
+
+func testPicker(t *testing.T, m *torrent.MetaInfo) *picker {
+	tt := torrent.NewTorrent(*m)
+	layout, err := torrent.NewFileLayout(&m.Info, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStorage(tt, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return newPicker(tt, s)
+}
+
+func allPieces(n int) *bitfield.Bitfield {
+	bf := bitfield.New(n)
+	for i := 0; i < n; i++ {
+		bf.Set(i)
+	}
+	return bf
+}
+
+func TestPickerRarestFirst(t *testing.T) {
+	m, _ := testTorrent(t, 32*1024, 4*32*1024+100)
+	p := testPicker(t, m)
+	all := allPieces(5)
+	some := bitfield.New(5)
+	some.Set(1)
+	some.Set(4)
+	p.addPeer(all)
+	p.addPeer(all)
+	p.addPeer(some)
+	p.peerHave(1)
+
+	// pieces 0, 2 and 3 are the rarest, then 4, then 1
+	var got []block
+	for {
+		b, ok := p.pick("a", all)
+		if !ok {
+			break
+		}
+		got = append(got, b)
+	}
+	want := []block{
+		{0, 0, BlockSize}, {0, BlockSize, BlockSize},
+		{2, 0, BlockSize}, {2, BlockSize, BlockSize},
+		{3, 0, BlockSize}, {3, BlockSize, BlockSize},
+		{4, 0, 100},
+		{1, 0, BlockSize}, {1, BlockSize, BlockSize},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted blocks %v got %v", want, got)
+	}
+
+	// a canceled block is handed out again, to a peer that has its piece
+	p.cancel(block{2, BlockSize, BlockSize})
+	if _, ok := p.pick("b", some); ok {
+		t.Fatal("picked a block of a piece the peer doesn't have")
+	}
+	if b, ok := p.pick("b", all); !ok || b != (block{2, BlockSize, BlockSize}) {
+		t.Fatalf("wanted the canceled block, got %v, %v", b, ok)
+	}
+}
+
+func TestPickerReceived(t *testing.T) {
+	m, files := testTorrent(t, 32*1024, 2*32*1024)
+	data := files[0]
+	p := testPicker(t, m)
+	all := allPieces(2)
+	p.addPeer(all)
+
+	b0, _ := p.pick("a", all)
+	b1, _ := p.pick("b", all)
+	if err := p.received("a", b0, data[:BlockSize]); err != nil {
+		t.Fatalf("received failed: %v", err)
+	}
+	// corrupt data from several peers isn't blamed on either
+	corrupt := bytes.Repeat([]byte{1}, BlockSize)
+	if err := p.received("b", b1, corrupt); err != nil {
+		t.Fatalf("received failed: %v", err)
+	}
+	if p.haveCopy().Has(0) || !p.retry[0] {
+		t.Fatal("corrupt piece accepted")
+	}
+
+	// the piece is now downloaded from whoever asks first
+	b0, _ = p.pick("a", all)
+	other, ok := p.pick("b", all)
+	if !ok || other.piece != 1 {
+		t.Fatalf("wanted a block of piece 1 for another peer, got %v, %v", other, ok)
+	}
+	b1, _ = p.pick("a", all)
+	p.received("a", b0, corrupt)
+	if err := p.received("a", b1, corrupt); err != errCorruptPiece {
+		t.Fatalf("expected errCorruptPiece, got %v", err)
+	}
+
+	// piece 1 comes from b
+	p.received("b", other, data[32*1024:][:BlockSize])
+	b, _ := p.pick("b", all)
+	if b != (block{1, BlockSize, BlockSize}) {
+		t.Fatalf("wanted the rest of piece 1, got %v", b)
+	}
+	p.received("b", b, data[32*1024+BlockSize:])
+	select {
+	case <-p.done:
+		t.Fatal("done before all pieces are written")
+	default:
+	}
+
+	// the owner leaving frees the piece for others
+	if b, _ := p.pick("a", all); b.piece != 0 {
+		t.Fatalf("wanted piece 0, got %v", b)
+	}
+	p.release("a")
+	b0, _ = p.pick("b", all)
+	b1, _ = p.pick("b", all)
+	if b0.piece != 0 || b1.piece != 0 {
+		t.Fatalf("wanted piece 0 after release, got %v and %v", b0, b1)
+	}
+	p.received("b", b0, data[:BlockSize])
+	p.received("b", b1, data[BlockSize:2*BlockSize])
+	if !p.haveCopy().Has(0) || p.retry[0] || p.downloaded != 2*32*1024 {
+		t.Fatal("piece 0 not written")
+	}
+	<-p.done
+	if err := p.result(); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+}
+
+func TestPickerTimeout(t *testing.T) {
+	m, _ := testTorrent(t, 32*1024, 32*1024)
+	p := testPicker(t, m)
+	all := allPieces(1)
+	p.addPeer(all)
+
+	b0, _ := p.pick("a", all)
+	b1, _ := p.pick("a", all)
+	p.timeout("a", b0)
+	// the block goes to another peer, not back to the slow one
+	if b, ok := p.pick("a", all); ok {
+		t.Fatalf("slow peer got %v again", b)
+	}
+	if b, ok := p.pick("b", all); !ok || b != b0 {
+		t.Fatalf("wanted %v for another peer, got %v, %v", b0, b, ok)
+	}
+	if _, ok := p.pick("b", all); ok {
+		t.Fatalf("picked %v while it is requested", b1)
+	}
+}
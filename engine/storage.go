@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+// storage writes pieces to the files of a torrent.
+type storage struct {
+	t     *torrent.Torrent
+	files []*os.File
+}
+
+// openStorage creates the files of layout, and the directories they are
+// in. Existing files are opened as they are.
+func openStorage(t *torrent.Torrent, layout *torrent.FileLayout) (*storage, error) {
+	s := &storage{t: t}
+	for i := range layout.Files {
+		path := layout.FullPath(i)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			s.Close()
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.files = append(s.files, f)
+	}
+	return s, nil
+}
+
+func (s *storage) writePiece(i int, data []byte) error {
+	for _, r := range s.t.PieceFileRanges(i) {
+		if _, err := s.files[r.File].WriteAt(data[:r.Length], r.Offset); err != nil {
+			return err
+		}
+		data = data[r.Length:]
+	}
+	return nil
+}
+
+func (s *storage) Close() error {
+	var err error
+	for _, f := range s.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
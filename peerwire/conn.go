@@ -0,0 +1,71 @@
+package peerwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize bounds the messages a Reader accepts when its
+// MaxMessageSize is zero. It fits a block of 128KiB, or the bitfield of
+// a torrent with eight million pieces.
+const DefaultMaxMessageSize = 1 << 20
+
+// A Reader reads length prefixed messages, typically from a net.Conn.
+type Reader struct {
+	// MaxMessageSize is the largest message, without its length prefix,
+	// that is read.
+	MaxMessageSize int
+
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage reads the next message. Messages that are longer than
+// MaxMessageSize or the wrong length for their type are errors.
+func (r *Reader) ReadMessage() (Message, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+		return nil, err
+	}
+	max := r.MaxMessageSize
+	if max == 0 {
+		max = DefaultMaxMessageSize
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if uint64(n) > uint64(max) {
+		return nil, fmt.Errorf("message of %d bytes is over the limit of %d", n, max)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parseMessage(data)
+}
+
+// A Writer writes length prefixed messages, typically to a net.Conn.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteMessage writes m with a single call to the underlying writer.
+func (w *Writer) WriteMessage(m Message) error {
+	b := append(w.buf[:0], 0, 0, 0, 0)
+	b = m.appendPayload(b)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	w.buf = b
+	_, err := w.w.Write(b)
+	return err
+}
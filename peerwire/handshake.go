@@ -0,0 +1,93 @@
+package peerwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const protocol = "BitTorrent protocol"
+
+// HandshakeLen is the length of an encoded handshake.
+const HandshakeLen = 1 + len(protocol) + 8 + 20 + 20
+
+// ErrInfoHashMismatch is returned by ReadHandshake when the peer wants a
+// different torrent.
+var ErrInfoHashMismatch = errors.New("peer handshake has the wrong info hash")
+
+// An Extension is a reserved bit of the handshake, counted from the high
+// bit of the first reserved byte.
+type Extension int
+
+const (
+	ExtensionProtocol Extension = 43 // BEP 10, reserved[5] & 0x10
+	ExtensionFast     Extension = 61 // BEP 6, reserved[7] & 0x04
+	ExtensionDHT      Extension = 63 // BEP 5, reserved[7] & 0x01
+)
+
+// Reserved are the bits of the handshake peers use to announce the
+// extensions they support.
+type Reserved [8]byte
+
+func (r Reserved) Has(e Extension) bool {
+	return r[e/8]&(0x80>>(e%8)) != 0
+}
+
+func (r *Reserved) Set(e Extension) {
+	r[e/8] |= 0x80 >> (e % 8)
+}
+
+// Handshake is the first message each side of a connection sends.
+type Handshake struct {
+	Reserved Reserved
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+func (h *Handshake) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, HandshakeLen)
+	b = append(b, byte(len(protocol)))
+	b = append(b, protocol...)
+	b = append(b, h.Reserved[:]...)
+	b = append(b, h.InfoHash[:]...)
+	return append(b, h.PeerID[:]...), nil
+}
+
+func (h *Handshake) UnmarshalBinary(data []byte) error {
+	if len(data) != HandshakeLen {
+		return fmt.Errorf("handshake of %d bytes", len(data))
+	}
+	if data[0] != byte(len(protocol)) || string(data[1:1+len(protocol)]) != protocol {
+		return errors.New("not a BitTorrent handshake")
+	}
+	data = data[1+len(protocol):]
+	copy(h.Reserved[:], data)
+	copy(h.InfoHash[:], data[8:])
+	copy(h.PeerID[:], data[28:])
+	return nil
+}
+
+// WriteHandshake writes h to w.
+func WriteHandshake(w io.Writer, h *Handshake) error {
+	b, _ := h.MarshalBinary()
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadHandshake reads a handshake from r and checks that it is for the
+// torrent with infoHash. It reads no further, so r can be passed on to
+// NewReader afterwards.
+func ReadHandshake(r io.Reader, infoHash [20]byte) (*Handshake, error) {
+	b := make([]byte, HandshakeLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var h Handshake
+	if err := h.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	if h.InfoHash != infoHash {
+		return nil, ErrInfoHashMismatch
+	}
+	return &h, nil
+}
@@ -0,0 +1,80 @@
+package peerwire
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHandshake = "\x13BitTorrent protocol" +
+	"\x00\x00\x00\x00\x00\x10\x00\x05" +
+	"infohashinfohashinfo" +
+	"-GT0001-abcdefghijkl"
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	h := &Handshake{}
+	h.Reserved.Set(ExtensionProtocol)
+	h.Reserved.Set(ExtensionFast)
+	h.Reserved.Set(ExtensionDHT)
+	copy(h.InfoHash[:], "infohashinfohashinfo")
+	copy(h.PeerID[:], "-GT0001-abcdefghijkl")
+
+	var buf bytes.Buffer
+	if err := WriteHandshake(&buf, h); err != nil {
+		t.Fatalf("WriteHandshake failed: %v", err)
+	}
+	if buf.String() != testHandshake {
+		t.Fatalf("wanted %q got %q", testHandshake, buf.String())
+	}
+	buf.WriteString("\x00\x00\x00\x01\x01")
+
+	got, err := ReadHandshake(&buf, h.InfoHash)
+	if err != nil {
+		t.Fatalf("ReadHandshake failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Fatalf("wanted %+v got %+v", h, got)
+	}
+	// the messages after the handshake are left alone
+	if m, err := NewReader(&buf).ReadMessage(); err != nil || m != (Unchoke{}) {
+		t.Fatalf("wanted unchoke, got %#v, %v", m, err)
+	}
+}
+
+func TestReserved(t *testing.T) {
+	var r Reserved
+	copy(r[:], "\x00\x00\x00\x00\x00\x10\x00\x01")
+	if !r.Has(ExtensionProtocol) || !r.Has(ExtensionDHT) || r.Has(ExtensionFast) {
+		t.Fatalf("wrong extensions in %x", r)
+	}
+	r.Set(ExtensionFast)
+	if r != (Reserved{0, 0, 0, 0, 0, 0x10, 0, 0x05}) {
+		t.Fatalf("wrong reserved bits %x", r)
+	}
+}
+
+func TestReadHandshakeErrors(t *testing.T) {
+	var infoHash [20]byte
+	copy(infoHash[:], "infohashinfohashinfo")
+	var testCases = []struct {
+		in          string
+		errContains string
+	}{
+		{"\x13BitTorrent protocol", "unexpected EOF"},
+		{"\x13BitTorrent protocoX" + testHandshake[20:], "not a BitTorrent handshake"},
+		{"\x12BitTorrent protocol" + testHandshake[20:], "not a BitTorrent handshake"},
+		{testHandshake[:28] + "otherhash" + testHandshake[37:], "wrong info hash"},
+	}
+	for _, tc := range testCases {
+		_, err := ReadHandshake(strings.NewReader(tc.in), infoHash)
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Fatalf("%q: expected error containing %q, got %v", tc.in, tc.errContains, err)
+		}
+	}
+
+	var h Handshake
+	if err := h.UnmarshalBinary([]byte(testHandshake[:67])); err == nil {
+		t.Fatal("expected error for short handshake, got nil")
+	}
+}
@@ -0,0 +1,207 @@
+// Package peerwire encodes and decodes the messages BitTorrent peers
+// exchange, as described in BEP 3.
+package peerwire
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ID identifies the type of a message. Keep-alives have none.
+type ID byte
+
+const (
+	IDChoke         ID = 0
+	IDUnchoke       ID = 1
+	IDInterested    ID = 2
+	IDNotInterested ID = 3
+	IDHave          ID = 4
+	IDBitfield      ID = 5
+	IDRequest       ID = 6
+	IDPiece         ID = 7
+	IDCancel        ID = 8
+	IDPort          ID = 9
+)
+
+var idNames = map[ID]string{
+	IDChoke:         "choke",
+	IDUnchoke:       "unchoke",
+	IDInterested:    "interested",
+	IDNotInterested: "not interested",
+	IDHave:          "have",
+	IDBitfield:      "bitfield",
+	IDRequest:       "request",
+	IDPiece:         "piece",
+	IDCancel:        "cancel",
+	IDPort:          "port",
+}
+
+func (id ID) String() string {
+	if name, ok := idNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("message %d", byte(id))
+}
+
+// A Message is one of the message types below.
+type Message interface {
+	// appendPayload appends the message without its length prefix to b.
+	appendPayload(b []byte) []byte
+}
+
+// KeepAlive is the empty message peers send to keep a connection open.
+type KeepAlive struct{}
+
+type Choke struct{}
+
+type Unchoke struct{}
+
+type Interested struct{}
+
+type NotInterested struct{}
+
+// Have announces that the sender has the piece Index.
+type Have struct {
+	Index uint32
+}
+
+// Bitfield has a bit for each piece, set if the sender has it. The high
+// bit of the first byte is piece 0, spare bits at the end are zero.
+type Bitfield struct {
+	Bits []byte
+}
+
+// Request asks for Length bytes of piece Index, starting at Begin.
+type Request struct {
+	Index, Begin, Length uint32
+}
+
+// Piece carries a block of piece Index starting at Begin.
+type Piece struct {
+	Index, Begin uint32
+	Block        []byte
+}
+
+// Cancel withdraws a Request.
+type Cancel struct {
+	Index, Begin, Length uint32
+}
+
+// Port is the port of the sender's DHT node (BEP 5).
+type Port struct {
+	Port uint16
+}
+
+// Unknown is a message of a type this package doesn't know, such as
+// those of extensions. Peers are expected to ignore them.
+type Unknown struct {
+	ID      ID
+	Payload []byte
+}
+
+func (KeepAlive) appendPayload(b []byte) []byte     { return b }
+func (Choke) appendPayload(b []byte) []byte         { return append(b, byte(IDChoke)) }
+func (Unchoke) appendPayload(b []byte) []byte       { return append(b, byte(IDUnchoke)) }
+func (Interested) appendPayload(b []byte) []byte    { return append(b, byte(IDInterested)) }
+func (NotInterested) appendPayload(b []byte) []byte { return append(b, byte(IDNotInterested)) }
+
+func (m Have) appendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(append(b, byte(IDHave)), m.Index)
+}
+
+func (m Bitfield) appendPayload(b []byte) []byte {
+	return append(append(b, byte(IDBitfield)), m.Bits...)
+}
+
+func (m Request) appendPayload(b []byte) []byte {
+	return appendBlockInfo(append(b, byte(IDRequest)), m.Index, m.Begin, m.Length)
+}
+
+func (m Piece) appendPayload(b []byte) []byte {
+	b = append(b, byte(IDPiece))
+	b = binary.BigEndian.AppendUint32(b, m.Index)
+	b = binary.BigEndian.AppendUint32(b, m.Begin)
+	return append(b, m.Block...)
+}
+
+func (m Cancel) appendPayload(b []byte) []byte {
+	return appendBlockInfo(append(b, byte(IDCancel)), m.Index, m.Begin, m.Length)
+}
+
+func (m Port) appendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint16(append(b, byte(IDPort)), m.Port)
+}
+
+func (m Unknown) appendPayload(b []byte) []byte {
+	return append(append(b, byte(m.ID)), m.Payload...)
+}
+
+func appendBlockInfo(b []byte, index, begin, length uint32) []byte {
+	b = binary.BigEndian.AppendUint32(b, index)
+	b = binary.BigEndian.AppendUint32(b, begin)
+	return binary.BigEndian.AppendUint32(b, length)
+}
+
+// parseMessage decodes a message without its length prefix. The message
+// keeps references to data.
+func parseMessage(data []byte) (Message, error) {
+	if len(data) == 0 {
+		return KeepAlive{}, nil
+	}
+	id, p := ID(data[0]), data[1:]
+
+	var size int
+	switch id {
+	case IDChoke, IDUnchoke, IDInterested, IDNotInterested:
+		size = 0
+	case IDHave:
+		size = 4
+	case IDRequest, IDCancel:
+		size = 12
+	case IDPort:
+		size = 2
+	case IDPiece:
+		if len(p) < 8 {
+			return nil, fmt.Errorf("%v message with %d byte payload", id, len(p))
+		}
+		return Piece{
+			Index: binary.BigEndian.Uint32(p),
+			Begin: binary.BigEndian.Uint32(p[4:]),
+			Block: p[8:],
+		}, nil
+	case IDBitfield:
+		return Bitfield{p}, nil
+	default:
+		return Unknown{id, p}, nil
+	}
+	if len(p) != size {
+		return nil, fmt.Errorf("%v message with %d byte payload", id, len(p))
+	}
+
+	switch id {
+	case IDChoke:
+		return Choke{}, nil
+	case IDUnchoke:
+		return Unchoke{}, nil
+	case IDInterested:
+		return Interested{}, nil
+	case IDNotInterested:
+		return NotInterested{}, nil
+	case IDHave:
+		return Have{binary.BigEndian.Uint32(p)}, nil
+	case IDRequest:
+		return Request{
+			Index:  binary.BigEndian.Uint32(p),
+			Begin:  binary.BigEndian.Uint32(p[4:]),
+			Length: binary.BigEndian.Uint32(p[8:]),
+		}, nil
+	case IDCancel:
+		return Cancel{
+			Index:  binary.BigEndian.Uint32(p),
+			Begin:  binary.BigEndian.Uint32(p[4:]),
+			Length: binary.BigEndian.Uint32(p[8:]),
+		}, nil
+	default: // IDPort
+		return Port{binary.BigEndian.Uint16(p)}, nil
+	}
+}
@@ -0,0 +1,126 @@
+package peerwire
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var messageTestCases = []struct {
+	msg  Message
+	wire string
+}{
+	{KeepAlive{}, "\x00\x00\x00\x00"},
+	{Choke{}, "\x00\x00\x00\x01\x00"},
+	{Unchoke{}, "\x00\x00\x00\x01\x01"},
+	{Interested{}, "\x00\x00\x00\x01\x02"},
+	{NotInterested{}, "\x00\x00\x00\x01\x03"},
+	{Have{0x01020304}, "\x00\x00\x00\x05\x04\x01\x02\x03\x04"},
+	{Bitfield{[]byte{0xa0, 0x01}}, "\x00\x00\x00\x03\x05\xa0\x01"},
+	{
+		Request{Index: 1, Begin: 0x4000, Length: 0x4000},
+		"\x00\x00\x00\x0d\x06\x00\x00\x00\x01\x00\x00\x40\x00\x00\x00\x40\x00",
+	},
+	{
+		Piece{Index: 1, Begin: 2, Block: []byte("abc")},
+		"\x00\x00\x00\x0c\x07\x00\x00\x00\x01\x00\x00\x00\x02abc",
+	},
+	{Piece{Index: 1, Begin: 2, Block: []byte{}}, "\x00\x00\x00\x09\x07\x00\x00\x00\x01\x00\x00\x00\x02"},
+	{
+		Cancel{Index: 1, Begin: 0x4000, Length: 0x4000},
+		"\x00\x00\x00\x0d\x08\x00\x00\x00\x01\x00\x00\x40\x00\x00\x00\x40\x00",
+	},
+	{Port{6881}, "\x00\x00\x00\x03\x09\x1a\xe1"},
+	{Unknown{ID: 20, Payload: []byte("\x00d1:md11:ut_metadatai3eee")}, "\x00\x00\x00\x1a\x14\x00d1:md11:ut_metadatai3eee"},
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, tc := range messageTestCases {
+		if err := w.WriteMessage(tc.msg); err != nil {
+			t.Fatalf("%#v: WriteMessage failed: %v", tc.msg, err)
+		}
+		if buf.String() != tc.wire {
+			t.Fatalf("%#v: wanted %q got %q", tc.msg, tc.wire, buf.String())
+		}
+		m, err := NewReader(&buf).ReadMessage()
+		if err != nil {
+			t.Fatalf("%q: ReadMessage failed: %v", tc.wire, err)
+		}
+		if !reflect.DeepEqual(m, tc.msg) {
+			t.Fatalf("%q: wanted %#v got %#v", tc.wire, tc.msg, m)
+		}
+	}
+}
+
+func TestMessageStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		w := NewWriter(client)
+		for _, tc := range messageTestCases {
+			if err := w.WriteMessage(tc.msg); err != nil {
+				return
+			}
+		}
+		client.Close()
+	}()
+	r := NewReader(server)
+	for _, tc := range messageTestCases {
+		m, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if !reflect.DeepEqual(m, tc.msg) {
+			t.Fatalf("wanted %#v got %#v", tc.msg, m)
+		}
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var testCases = []struct {
+		in          string
+		errContains string
+	}{
+		{"\x00\x00\x00\x02\x00\x00", "choke message with 1 byte payload"},
+		{"\x00\x00\x00\x04\x04\x00\x00\x00", "have message with 3 byte payload"},
+		{"\x00\x00\x00\x0e\x06\x00\x00\x00\x01\x00\x00\x40\x00\x00\x00\x40\x00\x00", "request message with 13 byte payload"},
+		{"\x00\x00\x00\x08\x07\x00\x00\x00\x01\x00\x00\x00", "piece message with 7 byte payload"},
+		{"\x00\x00\x00\x02\x09\x1a", "port message with 1 byte payload"},
+		{"\x00\x10\x00\x01\x07", "message of 1048577 bytes is over the limit of 1048576"},
+		{"\xff\xff\xff\xff", "over the limit"},
+		{"\x00\x00\x00\x05\x04\x00", "unexpected EOF"},
+		{"\x00\x00\x00\x05", "unexpected EOF"},
+		{"\x00\x00", "unexpected EOF"},
+	}
+	for _, tc := range testCases {
+		_, err := NewReader(strings.NewReader(tc.in)).ReadMessage()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Fatalf("%q: expected error containing %q, got %v", tc.in, tc.errContains, err)
+		}
+	}
+
+	r := NewReader(strings.NewReader("\x00\x00\x00\x05\x04\x00\x00\x00\x01"))
+	r.MaxMessageSize = 4
+	if _, err := r.ReadMessage(); err == nil || !strings.Contains(err.Error(), "limit of 4") {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
+
+func TestIDString(t *testing.T) {
+	if s := IDNotInterested.String(); s != "not interested" {
+		t.Fatalf("wanted %q got %q", "not interested", s)
+	}
+	if s := ID(20).String(); s != "message 20" {
+		t.Fatalf("wanted %q got %q", "message 20", s)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/filipochnik/btget/tracker"
+)
+
+// scrape prints the peer counts each tracker of a torrent has for it.
+func scrape(args []string) error {
+	if len(args) != 1 {
+		usage()
+	}
+	metaInfo, err := loadMetaInfo(args[0])
+	if err != nil {
+		return err
+	}
+	var infoHash [20]byte
+	copy(infoHash[:], metaInfo.InfoHash)
+
+	var urls []string
+	for _, tier := range tracker.NewTiers(metaInfo).URLs() {
+		urls = append(urls, tier...)
+	}
+	if len(urls) == 0 {
+		return errors.New("torrent has no trackers")
+	}
+
+	// trackers are asked at the same time, a dead one can take a while
+	results := make([]string, len(urls))
+	answered := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), tracker.DefaultTimeout)
+			defer cancel()
+			stats, err := tracker.NewClient(u).Scrape(ctx, infoHash)
+			s, ok := stats[infoHash]
+			switch {
+			case err != nil:
+				results[i] = err.Error()
+			case !ok:
+				results[i] = "torrent not known to tracker"
+			default:
+				results[i] = fmt.Sprintf("complete %d, incomplete %d, downloaded %d",
+					s.Complete, s.Incomplete, s.Downloaded)
+				mu.Lock()
+				answered++
+				mu.Unlock()
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	for i, u := range urls {
+		fmt.Printf("%s: %s\n", u, results[i])
+	}
+	if answered == 0 {
+		return errors.New("no tracker answered")
+	}
+	return nil
+}
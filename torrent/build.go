@@ -0,0 +1,257 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+// BuildOptions are the optional parts of a torrent made by Build.
+type BuildOptions struct {
+	// PieceLength is picked based on the total length if it is zero.
+	PieceLength int
+	// AnnounceList is a list of tiers of tracker URLs. The first URL is
+	// also the announce URL, the list itself is only written if there is
+	// more than one.
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	// CreationDate is left out if it is zero.
+	CreationDate time.Time
+	Private      bool
+	WebSeeds     []string
+	// Workers is the number of pieces hashed in parallel, the number of
+	// CPUs if it is zero.
+	Workers int
+}
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// the automatic piece length aims for at most this many pieces
+	targetPieces = 1500
+)
+
+// Build makes a torrent of the file or directory at path. In a directory
+// all regular files are included in lexical order, symbolic links and
+// other special files are skipped. Marshaling the result with bencode
+// gives the canonical .torrent file.
+func Build(path string, opts BuildOptions) (*MetaInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m MetaInfo
+	info := &m.Info
+	info.Name = filepath.Base(abs)
+	info.Private = opts.Private
+	var paths []string
+	var length int64
+	if st.Mode().IsRegular() {
+		info.Length = int(st.Size())
+		paths = []string{path}
+		length = st.Size()
+	} else {
+		info.Files, paths, err = walkFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range info.Files {
+			length += int64(f.Length)
+		}
+	}
+
+	info.PieceLength = opts.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = pickPieceLength(length)
+	}
+	if info.PieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length %d", info.PieceLength)
+	}
+	numPieces := (length + int64(info.PieceLength) - 1) / int64(info.PieceLength)
+	info.Pieces = make([]byte, numPieces*sha1.Size)
+	if err := hashPieces(NewTorrent(m), paths, opts.Workers); err != nil {
+		return nil, err
+	}
+
+	var trackers int
+	for _, tier := range opts.AnnounceList {
+		for _, url := range tier {
+			if trackers == 0 {
+				m.Announce = url
+			}
+			trackers++
+		}
+	}
+	if trackers > 1 {
+		m.AnnounceList = opts.AnnounceList
+	}
+	m.Comment = opts.Comment
+	m.CreatedBy = opts.CreatedBy
+	if !opts.CreationDate.IsZero() {
+		m.CreationDate = int(opts.CreationDate.Unix())
+	}
+	m.WebSeeds = opts.WebSeeds
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := bencode.Marshal(m.Info)
+	if err != nil {
+		return nil, err
+	}
+	m.InfoHash = infoHash(b)
+	return &m, nil
+}
+
+// walkFiles returns the regular files under dir, both as the torrent lists
+// them and as paths to open.
+func walkFiles(dir string) ([]FileDict, []string, error) {
+	var files []FileDict
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, FileDict{
+			Length: int(st.Size()),
+			Path:   strings.Split(filepath.ToSlash(rel), "/"),
+		})
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("%s: no files to add to the torrent", dir)
+	}
+	return files, paths, nil
+}
+
+// pickPieceLength returns the smallest power of two piece length that
+// keeps the number of pieces of a torrent of the given length reasonable.
+func pickPieceLength(length int64) int {
+	pieceLength := minPieceLength
+	for pieceLength < maxPieceLength && length/int64(pieceLength) > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces fills in the piece hashes of t, which must alias the MetaInfo
+// they go into, reading file i of t from paths[i].
+func hashPieces(t *Torrent, paths []string, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	pieces := make(chan int)
+	done := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := pieceHasher{
+				t:     t,
+				paths: paths,
+				buf:   make([]byte, 0, t.metaInfo.Info.PieceLength),
+				file:  -1,
+			}
+			defer h.close()
+			for i := range pieces {
+				if err := h.hash(i); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := 0; i < t.NumPieces(); i++ {
+		select {
+		case pieces <- i:
+		case <-done:
+			break loop
+		}
+	}
+	close(pieces)
+	wg.Wait()
+	return firstErr
+}
+
+// pieceHasher hashes pieces keeping the last file it read from open, since
+// consecutive pieces mostly come from the same file.
+type pieceHasher struct {
+	t     *Torrent
+	paths []string
+	buf   []byte
+	f     *os.File
+	file  int
+}
+
+func (h *pieceHasher) hash(i int) error {
+	h.buf = h.buf[:0]
+	for _, r := range h.t.PieceFileRanges(i) {
+		if r.File != h.file {
+			h.close()
+			f, err := os.Open(h.paths[r.File])
+			if err != nil {
+				return err
+			}
+			h.f, h.file = f, r.File
+		}
+		start := len(h.buf)
+		h.buf = h.buf[:start+int(r.Length)]
+		_, err := h.f.ReadAt(h.buf[start:], r.Offset)
+		if err == io.EOF {
+			err = errors.New("file shrank while it was being hashed")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", h.paths[r.File], err)
+		}
+	}
+	sum := sha1.Sum(h.buf)
+	copy(h.t.PieceHash(i), sum[:])
+	return nil
+}
+
+func (h *pieceHasher) close() {
+	if h.f != nil {
+		h.f.Close()
+		h.f, h.file = nil, -1
+	}
+}
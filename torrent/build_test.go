@@ -0,0 +1,141 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	big := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(big)
+	writeTestFile(t, filepath.Join(dir, "a"), []byte("abc"))
+	writeTestFile(t, filepath.Join(dir, "sub", "b"), big)
+	writeTestFile(t, filepath.Join(dir, "empty"), nil)
+	if err := os.Symlink("a", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Unix(1500000000, 0)
+	m, err := Build(dir, BuildOptions{
+		PieceLength:  1024,
+		AnnounceList: [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}},
+		Comment:      "test",
+		CreatedBy:    "btget",
+		CreationDate: date,
+		Private:      true,
+		WebSeeds:     []string{"http://seed/"},
+		Workers:      3,
+	})
+	if err != nil {
+		t.Fatalf("Error while building torrent: %v", err)
+	}
+
+	wantFiles := []FileDict{
+		{Length: 3, Path: []string{"a"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 5000, Path: []string{"sub", "b"}},
+	}
+	if !reflect.DeepEqual(m.Info.Files, wantFiles) {
+		t.Fatalf("wanted files %v got %v", wantFiles, m.Info.Files)
+	}
+	data := append([]byte("abc"), big...)
+	tor := NewTorrent(*m)
+	if tor.NumPieces() != 5 {
+		t.Fatalf("wanted 5 pieces, got %d", tor.NumPieces())
+	}
+	for i := 0; i < tor.NumPieces(); i++ {
+		start := i * 1024
+		sum := sha1.Sum(data[start : start+tor.PieceLength(i)])
+		if !bytes.Equal(tor.PieceHash(i), sum[:]) {
+			t.Fatalf("piece %d: wrong hash", i)
+		}
+	}
+
+	b, err := bencode.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bencode.IsCanonical(b) {
+		t.Fatalf("torrent is not canonical: %q", b)
+	}
+	m2, err := LoadMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Error while loading built torrent: %v", err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Fatalf("loading changed %+v to %+v", m, m2)
+	}
+	if m2.Announce != "http://a/announce" || len(m2.AnnounceList) != 2 ||
+		m2.CreationDate != 1500000000 || !m2.Info.Private ||
+		!reflect.DeepEqual(m2.WebSeeds, URLList{"http://seed/"}) {
+		t.Fatalf("wrong optional fields in %+v", m2)
+	}
+}
+
+func TestBuildSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.bin")
+	data := bytes.Repeat([]byte("x"), 40000)
+	writeTestFile(t, path, data)
+
+	m, err := Build(path, BuildOptions{AnnounceList: [][]string{{"http://a/announce"}}})
+	if err != nil {
+		t.Fatalf("Error while building torrent: %v", err)
+	}
+	if m.Info.Name != "file.bin" || m.Info.Length != 40000 || m.Info.Files != nil {
+		t.Fatalf("wrong info %+v", m.Info)
+	}
+	if m.Info.PieceLength != minPieceLength {
+		t.Fatalf("wrong piece length %d", m.Info.PieceLength)
+	}
+	if m.Announce != "http://a/announce" || m.AnnounceList != nil {
+		t.Fatalf("wrong trackers %q %q", m.Announce, m.AnnounceList)
+	}
+	last := sha1.Sum(data[2*minPieceLength:])
+	if !bytes.Equal(m.Info.Pieces[2*sha1.Size:], last[:]) {
+		t.Fatal("wrong hash of the last piece")
+	}
+
+	if _, err := Build(filepath.Join(t.TempDir(), "missing"), BuildOptions{}); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+	if _, err := Build(t.TempDir(), BuildOptions{}); err == nil {
+		t.Fatal("expected error for empty directory, got nil")
+	}
+}
+
+func TestPickPieceLength(t *testing.T) {
+	var testCases = []struct {
+		length int64
+		out    int
+	}{
+		{0, 16 << 10},
+		{1500 * 16 << 10, 16 << 10},
+		{1500*16<<10 + 16<<10, 32 << 10},
+		{4 << 30, 4 << 20},
+		{1 << 50, 16 << 20},
+	}
+	for _, tc := range testCases {
+		if out := pickPieceLength(tc.length); out != tc.out {
+			t.Fatalf("pickPieceLength(%d): wanted %d got %d", tc.length, tc.out, out)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package torrent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+func FuzzUnmarshalMetaInfo(f *testing.F) {
+	files, err := filepath.Glob("../testdata/*.torrent")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte("d4:infod6:lengthi3e4:name1:a12:piece lengthi1e6:pieces0:ee"))
+	f.Add([]byte("d4:infod5:filesld6:lengthi1e4:pathl1:aeee4:name1:d12:piece lengthi1e6:pieces0:ee"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if m, err := LoadMetaInfo(bytes.NewReader(data)); err == nil {
+			if err := m.Validate(); err != nil {
+				t.Fatalf("LoadMetaInfo accepted %q but Validate rejects it: %v", data, err)
+			}
+		}
+
+		var m MetaInfo
+		if err := bencode.Unmarshal(data, &m); err != nil {
+			return
+		}
+		b, err := bencode.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal of %#v failed: %v", m, err)
+		}
+		var m2 MetaInfo
+		if err := bencode.Unmarshal(b, &m2); err != nil {
+			t.Fatalf("Unmarshal of marshaled %q failed: %v", b, err)
+		}
+		if !reflect.DeepEqual(m, m2) {
+			t.Fatalf("round trip changed %#v to %#v", m, m2)
+		}
+	})
+}
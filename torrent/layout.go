@@ -0,0 +1,160 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FileLayout is where the files of a torrent go on disk. Paths in a torrent
+// come from whoever made it, so they are sanitized before use to make sure
+// that every file ends up under the download directory whatever the torrent
+// says. Each path component is treated as follows:
+//
+//   - empty components and "." are dropped, a path left with no components
+//     is an error
+//   - ".." is an error
+//   - path separators, NUL and other control characters and the characters
+//     Windows does not allow in file names (<>:"|?*) are replaced with '_',
+//     so an absolute path such as "/etc/passwd" becomes "_etc_passwd"
+//   - invalid UTF-8 is replaced with U+FFFD
+//   - trailing dots and spaces, which Windows strips, are replaced with '_'
+//   - names Windows reserves for devices, such as CON, NUL or COM1.txt in
+//     any case, get a '_' prefix
+//   - components longer than 255 bytes are an error
+//
+// The same rules apply everywhere, so a torrent has the same layout on every
+// platform. Two files with the same sanitized path, or a file whose path is
+// also a directory of another file, are an error.
+type FileLayout struct {
+	Dir   string
+	Files []LayoutFile
+}
+
+// LayoutFile is a single file of a FileLayout.
+type LayoutFile struct {
+	// Path is relative to the download directory and uses the OS syntax.
+	Path   string
+	Length int64
+	// Offset is where the file starts in the torrent's data, which is all
+	// the files concatenated in order.
+	Offset int64
+}
+
+const maxComponentLength = 255
+
+// NewFileLayout lays out the files described by info under directory dir.
+// A single file torrent is the file Name, a multi-file torrent a directory
+// Name containing the files.
+func NewFileLayout(info *InfoDict, dir string) (*FileLayout, error) {
+	name, err := sanitizeComponent(info.Name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
+	}
+	if name == "" {
+		return nil, errors.New("invalid name: empty file name")
+	}
+
+	l := &FileLayout{Dir: dir}
+	if info.Files == nil {
+		l.Files = []LayoutFile{{Path: name, Length: int64(info.Length)}}
+		return l, nil
+	}
+
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	var offset int64
+	for i, f := range info.Files {
+		if f.Length < 0 {
+			return nil, fmt.Errorf("file %d has negative length %d", i, f.Length)
+		}
+		components := []string{name}
+		for _, c := range f.Path {
+			c, err := sanitizeComponent(c)
+			if err != nil {
+				return nil, fmt.Errorf("file %d has invalid path: %v", i, err)
+			}
+			if c != "" {
+				components = append(components, c)
+			}
+		}
+		if len(components) == 1 {
+			return nil, fmt.Errorf("file %d has an empty path", i)
+		}
+
+		p := filepath.Join(components...)
+		if !filepath.IsLocal(p) {
+			// can't happen after sanitizing, but this is the one thing
+			// that must hold
+			return nil, fmt.Errorf("file %d has path %q outside the download directory", i, p)
+		}
+		if files[p] || dirs[p] {
+			return nil, fmt.Errorf("file %d has path %q, which is already in use", i, p)
+		}
+		files[p] = true
+		for j := 1; j < len(components); j++ {
+			d := filepath.Join(components[:j]...)
+			if files[d] {
+				return nil, fmt.Errorf("file %d has path %q, but %q is a file", i, p, d)
+			}
+			dirs[d] = true
+		}
+
+		l.Files = append(l.Files, LayoutFile{Path: p, Length: int64(f.Length), Offset: offset})
+		offset += int64(f.Length)
+	}
+	return l, nil
+}
+
+// FullPath returns the path of the i-th file including the download directory.
+func (l *FileLayout) FullPath(i int) string {
+	return filepath.Join(l.Dir, l.Files[i].Path)
+}
+
+// sanitizeComponent applies the rules described at FileLayout to a single
+// path component. It returns "" for components that should be dropped.
+func sanitizeComponent(c string) (string, error) {
+	switch c {
+	case "", ".":
+		return "", nil
+	case "..":
+		return "", errors.New(`file name ".." refers to the parent directory`)
+	}
+	var sb strings.Builder
+	for _, r := range c {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\<>:"|?*`, r) {
+			r = '_'
+		}
+		sb.WriteRune(r)
+	}
+	s := sb.String()
+
+	trimmed := strings.TrimRight(s, ". ")
+	s = trimmed + strings.Repeat("_", len(s)-len(trimmed))
+
+	if isReservedName(s) {
+		s = "_" + s
+	}
+	if len(s) > maxComponentLength {
+		return "", fmt.Errorf("file name %.20q... is longer than %d bytes", c, maxComponentLength)
+	}
+	return s, nil
+}
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// isReservedName reports whether Windows treats name as a device, which it
+// does whatever the extension.
+func isReservedName(name string) bool {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return reservedNames[strings.ToUpper(strings.TrimRight(name, " "))]
+}
@@ -0,0 +1,136 @@
+package torrent
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+// hostileInfo decodes an info dict with the given name and files without
+// validating it, the way a careless client would.
+func hostileInfo(t *testing.T, name, files string) *InfoDict {
+	in := "d4:name" + name
+	if files != "" {
+		in += "5:files" + files
+	} else {
+		in += "6:lengthi1e"
+	}
+	in += "12:piece lengthi1e6:pieces0:e"
+	var info InfoDict
+	if err := bencode.Unmarshal([]byte(in), &info); err != nil {
+		t.Fatalf("Error while unmarshalling %q: %v", in, err)
+	}
+	return &info
+}
+
+func TestFileLayout(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		files string
+		out   []string
+	}{
+		{"5:a.iso", "", []string{"a.iso"}},
+		{"11:/etc/passwd", "", []string{"_etc_passwd"}},
+		{"3:CON", "", []string{"_CON"}},
+		{
+			"1:d",
+			"ld6:lengthi1e4:pathl1:a1:bee" +
+				"d6:lengthi2e4:pathl1:ceee",
+			[]string{"d/a/b", "d/c"},
+		},
+		{"1:d", "ld6:lengthi1e4:pathl11:/etc/passwdeee", []string{"d/_etc_passwd"}},
+		{"1:d", "ld6:lengthi1e4:pathl10:..\\..\\evileee", []string{"d/.._.._evil"}},
+		{"1:d", "ld6:lengthi1e4:pathl7:C:\\evileee", []string{"d/C__evil"}},
+		{"1:d", "ld6:lengthi1e4:pathl0:1:.1:a0:eee", []string{"d/a"}},
+		{"1:d", "ld6:lengthi1e4:pathl3:a\x00beee", []string{"d/a_b"}},
+		{"1:d", "ld6:lengthi1e4:pathl5:a\nb\x7fceee", []string{"d/a_b_c"}},
+		{"1:d", "ld6:lengthi1e4:pathl9:a<b>c|d?*eee", []string{"d/a_b_c_d__"}},
+		{"1:d", "ld6:lengthi1e4:pathl5:nul.x3:aux5:Com1 eee", []string{"d/_nul.x/_aux/Com1_"}},
+		{"1:d", "ld6:lengthi1e4:pathl5:a. . 3:...eee", []string{"d/a____/___"}},
+		{"1:d", "ld6:lengthi1e4:pathl3:\xff\xfeaeee", []string{"d/\ufffd\ufffda"}},
+		{"1:d", "ld6:lengthi1e4:pathl7:console4:LPT05:COM10eee", []string{"d/console/LPT0/COM10"}},
+	}
+	for _, tc := range testCases {
+		info := hostileInfo(t, tc.name, tc.files)
+		l, err := NewFileLayout(info, "downloads")
+		if err != nil {
+			t.Fatalf("NewFileLayout %q %q: unexpected error %v", tc.name, tc.files, err)
+		}
+		var paths []string
+		for i, f := range l.Files {
+			paths = append(paths, filepath.ToSlash(f.Path))
+			if full := l.FullPath(i); !strings.HasPrefix(full, "downloads"+string(filepath.Separator)) {
+				t.Fatalf("NewFileLayout %q %q: %q is outside the download directory",
+					tc.name, tc.files, full)
+			}
+		}
+		if !reflect.DeepEqual(paths, tc.out) {
+			t.Fatalf("NewFileLayout %q %q: wanted %q got %q", tc.name, tc.files, tc.out, paths)
+		}
+	}
+}
+
+func TestFileLayoutOffsets(t *testing.T) {
+	info := hostileInfo(t, "1:d", "ld6:lengthi3e4:pathl1:aeed6:lengthi0e4:pathl1:beed6:lengthi5e4:pathl1:ceee")
+	l, err := NewFileLayout(info, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LayoutFile{
+		{Path: filepath.Join("d", "a"), Length: 3, Offset: 0},
+		{Path: filepath.Join("d", "b"), Length: 0, Offset: 3},
+		{Path: filepath.Join("d", "c"), Length: 5, Offset: 3},
+	}
+	if !reflect.DeepEqual(l.Files, want) {
+		t.Fatalf("wanted %v got %v", want, l.Files)
+	}
+}
+
+func TestFileLayoutHostile(t *testing.T) {
+	long := strings.Repeat("x", 256)
+	var testCases = []struct {
+		name        string
+		files       string
+		errContains string
+	}{
+		{"2:..", "", "parent directory"},
+		{"1:.", "", "empty file name"},
+		{"0:", "", "empty file name"},
+		{"256:" + long, "", "longer than 255 bytes"},
+		{"1:d", "ld6:lengthi1e4:pathl2:..2:..3:etc6:passwdeee", "parent directory"},
+		{"1:d", "ld6:lengthi1e4:pathl1:a2:..2:..1:beee", "parent directory"},
+		{"1:d", "ld6:lengthi1e4:pathleee", "empty path"},
+		{"1:d", "ld6:lengthi1e4:pathl0:1:.eee", "empty path"},
+		{"1:d", "ld6:lengthi1e4:pathl256:" + long + "eee", "longer than 255 bytes"},
+		{"1:d", "ld6:lengthi-1e4:pathl1:aeee", "negative length"},
+		{
+			"1:d",
+			"ld6:lengthi1e4:pathl1:aeed6:lengthi1e4:pathl1:aeee",
+			"already in use",
+		},
+		{
+			// both sanitize to the same name
+			"1:d",
+			"ld6:lengthi1e4:pathl3:a/beed6:lengthi1e4:pathl3:a:beee",
+			"already in use",
+		},
+		{
+			"1:d",
+			"ld6:lengthi1e4:pathl1:a1:beed6:lengthi1e4:pathl1:aeee",
+			"already in use",
+		},
+		{
+			"1:d",
+			"ld6:lengthi1e4:pathl1:aeed6:lengthi1e4:pathl1:a1:beee",
+			"is a file",
+		},
+	}
+	for _, tc := range testCases {
+		info := hostileInfo(t, tc.name, tc.files)
+		_, err := NewFileLayout(info, "downloads")
+		assertErrContains(t, err, tc.errContains)
+	}
+}
@@ -3,72 +3,248 @@ package torrent
 import (
 	"crypto/sha1"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 
 	"github.com/filipochnik/btget/bencode"
 )
 
 type MetaInfo struct {
-	Info         InfoDict `bencode:"info"`
-	InfoHash     []byte
-	Announce     string     `bencode:"announce"`
-	AnnounceList [][]string `bencode:"announce-list"`
-	CreationDate int        `bencode:"creation date"`
-	Comment      string     `bencode:"comment"`
-	CreatedBy    string     `bencode:"created by"`
-	Encoding     string     `bencode:"encoding"`
+	Info         InfoDict   `bencode:"info,required"`
+	InfoHash     []byte     `bencode:"-"`
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	CreationDate int        `bencode:"creation date,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	Encoding     string     `bencode:"encoding,omitempty"`
+	WebSeeds     URLList    `bencode:"url-list,omitempty"`
+
+	// InfoNotCanonical is set by LoadMetaInfo when the info dict is not
+	// canonical bencode. The info hash is computed over the bytes as they
+	// are in the file, so clients that re-encode the dict get another one.
+	InfoNotCanonical bool `bencode:"-"`
+}
+
+// URLList is the list of web seeds of a torrent (BEP 19). A single URL may
+// be given as a string instead of a list.
+type URLList []string
+
+func (l *URLList) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		return bencode.Unmarshal(data, (*[]string)(l))
+	}
+	var s string
+	if err := bencode.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		// some clients write an empty string for no web seeds
+		*l = nil
+		return nil
+	}
+	*l = URLList{s}
+	return nil
 }
 
 type InfoDict struct {
-	PieceLength int    `bencode:"piece length"`
-	Pieces      []byte `bencode:"pieces"`
-	Name        string `bencode:"name"`
+	PieceLength int    `bencode:"piece length,required"`
+	Pieces      []byte `bencode:"pieces,required"`
+	Name        string `bencode:"name,required"`
+	Private     bool   `bencode:"private,omitempty"`
 
 	// Single File Mode
-	Length int `bencode:"length"`
+	Length int `bencode:"length,omitempty"`
 
 	// Multiple Files Mode
-	Files []FileDict `bencode:"files"`
+	Files []FileDict `bencode:"files,omitempty"`
 }
 
 type FileDict struct {
-	Length int      `bencode:"length"`
-	Path   []string `bencode:"path"`
+	Length int      `bencode:"length,required"`
+	Path   []string `bencode:"path,required"`
+}
+
+// LoadMetaInfo reads a torrent from r and checks it with Validate. A torrent
+// that gives a top-level key, such as info, more than once is rejected.
+func LoadMetaInfo(r io.Reader) (*MetaInfo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetaInfo(data)
 }
 
+// LoadMetaInfoFile is like LoadMetaInfo but reads the torrent from a file.
+func LoadMetaInfoFile(path string) (*MetaInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseMetaInfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// metaInfoFile is a MetaInfo as it is decoded from a torrent file. The info
+// dict is kept as it appears in the file, so that the info hash is computed
+// over the same bytes Info is decoded from.
+type metaInfoFile struct {
+	MetaInfo
+	RawInfo bencode.Value `bencode:"info,required"`
+}
+
+// NewMetaInfo loads the torrent file at filePath and panics if it can't.
+//
+// Deprecated: use LoadMetaInfoFile, which returns an error instead.
 func NewMetaInfo(filePath string) *MetaInfo {
-	data, err := ioutil.ReadFile(filePath)
+	m, err := LoadMetaInfoFile(filePath)
 	if err != nil {
 		panic(err)
 	}
-	var m MetaInfo
-	bencode.Unmarshal(data, &m)
-	m.InfoHash = infoHash(data)
-	return &m
+	return m
+}
+
+func parseMetaInfo(data []byte) (*MetaInfo, error) {
+	// data is not modified after this, so pieces and the raw info dict
+	// can alias it rather than being copied
+	var f metaInfoFile
+	dec := bencode.NewBytesDecoder(data)
+	dec.AliasInput()
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	if off := dec.InputOffset(); off != int64(len(data)) {
+		return nil, fmt.Errorf("trailing data after the torrent at offset %d", off)
+	}
+	// with a key given twice the last value is decoded, other clients
+	// may take the first
+	if err := checkDuplicateKeys(data); err != nil {
+		return nil, err
+	}
+	m := f.MetaInfo
+	// an empty files list decodes to nil, as if there were none, so
+	// Validate can't tell it is there
+	_, hasFiles := f.RawInfo.Get("files")
+	_, hasLength := f.RawInfo.Get("length")
+	if hasFiles && hasLength {
+		return nil, errors.New("info has both length and files")
+	}
+	info := f.RawInfo.Raw()
+	dec = bencode.NewBytesDecoder(info)
+	dec.AliasInput()
+	if err := dec.Decode(&m.Info); err != nil {
+		return nil, fmt.Errorf("info: %w", err)
+	}
+	m.InfoNotCanonical = !bencode.IsCanonical(info)
+	m.InfoHash = infoHash(info)
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks that the info dict describes a consistent set of files
+// and pieces: the torrent is in exactly one of single-file and multi-file
+// mode, there is one 20-byte SHA-1 hash for each piece, every file path
+// component is accepted by ValidPathComponent, and the files can be laid
+// out on disk with NewFileLayout.
+func (m *MetaInfo) Validate() error {
+	info := &m.Info
+	if info.PieceLength <= 0 {
+		return fmt.Errorf("invalid piece length %d", info.PieceLength)
+	}
+	if len(info.Pieces)%sha1.Size != 0 {
+		return fmt.Errorf("pieces length %d is not a multiple of %d",
+			len(info.Pieces), sha1.Size)
+	}
+	if _, err := NewFileLayout(info, ""); err != nil {
+		return err
+	}
+
+	var length int64
+	switch {
+	case info.Files != nil && info.Length != 0:
+		return errors.New("info has both length and files")
+	case info.Files != nil:
+		for i, f := range info.Files {
+			// the layout drops empty components, but a torrent with them
+			// is malformed
+			for _, c := range f.Path {
+				if err := ValidPathComponent(c); err != nil {
+					return fmt.Errorf("file %d has invalid path: %v", i, err)
+				}
+			}
+			if length > math.MaxInt64-int64(f.Length) {
+				return errors.New("total length of files overflows")
+			}
+			length += int64(f.Length)
+		}
+	case info.Length > 0:
+		length = int64(info.Length)
+	default:
+		return errors.New("info has neither a positive length nor files")
+	}
+
+	pieceLength := int64(info.PieceLength)
+	want := length / pieceLength
+	if length%pieceLength != 0 {
+		want++
+	}
+	if got := int64(len(info.Pieces) / sha1.Size); got != want {
+		return fmt.Errorf("torrent of %d bytes in pieces of %d has %d piece hashes, want %d",
+			length, pieceLength, got, want)
+	}
+	return nil
 }
 
-func infoHash(data []byte) []byte {
-	b, err := infoBencode(data)
+// ValidPathComponent reports why name can't be used as a file or directory
+// name, or returns nil if it can. Names are sanitized as described at
+// FileLayout, so only those that are rejected there or sanitize to nothing,
+// such as "", "." and "..", are errors.
+func ValidPathComponent(name string) error {
+	c, err := sanitizeComponent(name)
 	if err != nil {
-		// TODO: handle this
-		panic(err)
+		return err
 	}
-	hash := sha1.New()
-	hash.Write(b)
-	return hash.Sum(nil)
+	if c == "" {
+		return errors.New("empty file name")
+	}
+	return nil
 }
 
-type infoExtractor struct {
-	// TODO add raw type to bencode lib
-	Info interface{} `bencode:"info"`
+func infoHash(info []byte) []byte {
+	hash := sha1.New()
+	hash.Write(info)
+	return hash.Sum(nil)
 }
 
-func infoBencode(data []byte) ([]byte, error) {
-	var infoExt infoExtractor
-	bencode.Unmarshal(data, &infoExt)
-	m, ok := infoExt.Info.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("info is not a dict")
+// checkDuplicateKeys returns an error if a key appears more than once in
+// the top-level dict of a torrent.
+func checkDuplicateKeys(data []byte) error {
+	s := bencode.NewBytesScanner(data)
+	if _, err := s.Next(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			return err
+		}
+		if tok.Kind == bencode.End {
+			return nil
+		}
+		if seen[string(tok.Bytes)] {
+			return fmt.Errorf("duplicate key %q", tok.Bytes)
+		}
+		seen[string(tok.Bytes)] = true
+		if err := s.SkipValue(); err != nil {
+			return err
+		}
 	}
-	return bencode.Marshal(m)
 }
@@ -0,0 +1,250 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+func TestLoadMetaInfoFile(t *testing.T) {
+	m, err := LoadMetaInfoFile("../testdata/ubuntu-17.10.1-desktop-amd64.iso.torrent")
+	if err != nil {
+		t.Fatalf("Error while loading torrent: %v", err)
+	}
+	if m.Info.Name != "ubuntu-17.10.1-desktop-amd64.iso" {
+		t.Fatalf("wrong name %q", m.Info.Name)
+	}
+	if len(m.InfoHash) != sha1.Size {
+		t.Fatalf("info hash has length %d", len(m.InfoHash))
+	}
+
+	_, err = LoadMetaInfoFile("../testdata/missing.torrent")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestNewMetaInfo(t *testing.T) {
+	m := NewMetaInfo("../testdata/ubuntu-17.10.1-desktop-amd64.iso.torrent")
+	if m.Info.Name != "ubuntu-17.10.1-desktop-amd64.iso" {
+		t.Fatalf("wrong name %q", m.Info.Name)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a missing file")
+		}
+	}()
+	NewMetaInfo("../testdata/missing.torrent")
+}
+
+func TestLoadMetaInfo(t *testing.T) {
+	var testCases = []struct {
+		in          string
+		errContains string
+	}{
+		{"d4:infod6:lengthi3e4:name1:a12:piece lengthi2e6:pieces40:" +
+			strings.Repeat("x", 40) + "ee", ""},
+		{"", "EOF"},
+		{"i1e", "cannot unmarshal integer"},
+		{"de", `missing required key "info"`},
+		{"d4:infod6:lengthi3e4:name1:a12:piece lengthi2e6:pieces3:xxxee", "not a multiple of 20"},
+		{"d4:infoi1ee", "cannot unmarshal integer"},
+		{"d4:infod5:filesle6:lengthi3e4:name1:a12:piece lengthi2e6:pieces40:" +
+			strings.Repeat("x", 40) + "ee", "both length and files"},
+		{"d4:infod6:lengthi3e4:name1:a12:piece lengthi2e6:pieces40:" +
+			strings.Repeat("x", 40) + "ee\n", "trailing data"},
+		{"d4:infod5:filesld6:lengthi3e4:pathl0:1:aeee4:name1:d12:piece lengthi4e6:pieces20:" +
+			strings.Repeat("x", 20) + "ee", "invalid path"},
+	}
+	for _, tc := range testCases {
+		m, err := LoadMetaInfo(strings.NewReader(tc.in))
+		if tc.errContains == "" {
+			if err != nil {
+				t.Fatalf("Error while loading %q: %v", tc.in, err)
+			}
+			if m.Info.Length != 3 {
+				t.Fatalf("Load %q: wrong length %d", tc.in, m.Info.Length)
+			}
+			continue
+		}
+		assertErrContains(t, err, tc.errContains)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pieces := func(n int) []byte { return bytes.Repeat([]byte("h"), n*sha1.Size) }
+	var testCases = []struct {
+		info        InfoDict
+		errContains string
+	}{
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "a", Length: 4}, ""},
+		{InfoDict{PieceLength: 4, Pieces: pieces(2), Name: "a", Length: 5}, ""},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(2), Name: "d", Files: []FileDict{
+				{Length: 3, Path: []string{"x", "y"}},
+				{Length: 0, Path: []string{"z"}},
+				{Length: 5, Path: []string{"w"}},
+			}},
+			"",
+		},
+		// names are sanitized rather than rejected, see FileLayout
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "a/b", Files: []FileDict{
+				{Length: 4, Path: []string{"c\x00d"}},
+			}},
+			"",
+		},
+
+		{InfoDict{PieceLength: 0, Pieces: pieces(1), Name: "a", Length: 4}, "piece length"},
+		{InfoDict{PieceLength: 4, Pieces: []byte("abc"), Name: "a", Length: 4}, "multiple of 20"},
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "a", Length: 5}, "has 1 piece hashes, want 2"},
+		{InfoDict{PieceLength: 4, Pieces: pieces(2), Name: "a", Length: 4}, "has 2 piece hashes, want 1"},
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "a"}, "neither"},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "a", Length: 4,
+				Files: []FileDict{{Length: 4, Path: []string{"a"}}}},
+			"both",
+		},
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "", Length: 4}, "invalid name"},
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "..", Length: 4}, "invalid name"},
+		{InfoDict{PieceLength: 4, Pieces: pieces(1), Name: ".", Length: 4}, "invalid name"},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: 4, Path: []string{}}}},
+			"empty path",
+		},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: 4, Path: []string{"a", ".."}}}},
+			"invalid path",
+		},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: 4, Path: []string{"", "a"}}}},
+			"invalid path",
+		},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: 4, Path: []string{"a", "."}}}},
+			"invalid path",
+		},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: 2, Path: []string{"a/b"}}, {Length: 2, Path: []string{"a:b"}}}},
+			"already in use",
+		},
+		{
+			InfoDict{PieceLength: 4, Pieces: pieces(1), Name: "d",
+				Files: []FileDict{{Length: -4, Path: []string{"a"}}}},
+			"negative length",
+		},
+	}
+	for _, tc := range testCases {
+		m := MetaInfo{Info: tc.info}
+		err := m.Validate()
+		if tc.errContains == "" {
+			if err != nil {
+				t.Fatalf("Validate %+v: unexpected error %v", tc.info, err)
+			}
+			continue
+		}
+		assertErrContains(t, err, tc.errContains)
+	}
+}
+
+func TestLoadMetaInfoRoundTrip(t *testing.T) {
+	in := MetaInfo{
+		Info: InfoDict{
+			PieceLength: 16,
+			Pieces:      bytes.Repeat([]byte{1}, sha1.Size),
+			Name:        "file",
+			Length:      10,
+		},
+		Announce: "http://tracker.example/announce",
+	}
+	b, err := bencode.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Error while loading %q: %v", b, err)
+	}
+	info, err := bencode.Marshal(in.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.InfoHash, infoHash(info)) {
+		t.Fatalf("wrong info hash %x", m.InfoHash)
+	}
+}
+
+func TestLoadMetaInfoNotCanonical(t *testing.T) {
+	var testCases = []struct {
+		info         string
+		notCanonical bool
+	}{
+		{"d6:lengthi3e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("x", 20) + "e", false},
+		{"d4:name1:a6:lengthi3e12:piece lengthi4e6:pieces20:" + strings.Repeat("x", 20) + "e", true},
+		{"d6:lengthi03e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("x", 20) + "e", true},
+	}
+	for _, tc := range testCases {
+		m, err := LoadMetaInfo(strings.NewReader("d4:info" + tc.info + "e"))
+		if err != nil {
+			t.Fatalf("Error while loading %q: %v", tc.info, err)
+		}
+		if m.InfoNotCanonical != tc.notCanonical {
+			t.Fatalf("Load %q: wanted InfoNotCanonical %v got %v", tc.info, tc.notCanonical, m.InfoNotCanonical)
+		}
+	}
+}
+
+func TestLoadMetaInfoDuplicateKeys(t *testing.T) {
+	info := "d6:lengthi3e4:name1:a12:piece lengthi4e6:pieces20:" + strings.Repeat("x", 20) + "e"
+	var testCases = []string{
+		"d4:info" + info + "4:info" + info + "e",
+		"d8:announce1:a4:info" + info + "8:announce1:be",
+	}
+	for _, tc := range testCases {
+		_, err := LoadMetaInfo(strings.NewReader(tc))
+		assertErrContains(t, err, "duplicate key")
+	}
+}
+
+func TestUnmarshalURLList(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		out URLList
+	}{
+		{"l1:a1:be", URLList{"a", "b"}},
+		{"le", nil},
+		{"1:a", URLList{"a"}},
+		{"0:", nil},
+	}
+	for _, tc := range testCases {
+		var m struct {
+			WebSeeds URLList `bencode:"url-list"`
+		}
+		err := bencode.Unmarshal([]byte("d8:url-list"+tc.in+"e"), &m)
+		if err != nil {
+			t.Fatalf("Error while unmarshalling %q: %v", tc.in, err)
+		}
+		if !reflect.DeepEqual(m.WebSeeds, tc.out) {
+			t.Fatalf("Unmarshal %q err: wanted %q got %q", tc.in, tc.out, m.WebSeeds)
+		}
+	}
+}
+
+func assertErrContains(t *testing.T, err error, contains string) {
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), contains) {
+		t.Fatalf("expected error containing \"%s\", got \"%s\" instead", contains, err.Error())
+	}
+}
@@ -0,0 +1,121 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+type Peer struct {
+	ID   []byte
+	IP   net.IP
+	Port uint16
+}
+
+func (p *Peer) Addr() string {
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
+}
+
+// Peers is a peer list as sent by trackers. It decodes both the compact
+// string format (BEP 23) and the original list of dictionaries.
+type Peers []Peer
+
+type peerDict struct {
+	ID   []byte `bencode:"peer id"`
+	IP   string `bencode:"ip"`
+	Port uint16 `bencode:"port"`
+}
+
+const (
+	compactPeerLen  = 6
+	compactPeer6Len = 18
+)
+
+func (ps *Peers) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		var dicts []peerDict
+		err := bencode.Unmarshal(data, &dicts)
+		if err != nil {
+			return err
+		}
+		peers := make(Peers, 0, len(dicts))
+		for _, d := range dicts {
+			ip := net.ParseIP(d.IP)
+			if ip == nil {
+				// the spec also allows DNS names here, we don't resolve them
+				continue
+			}
+			peers = append(peers, Peer{ID: d.ID, IP: ip, Port: d.Port})
+		}
+		*ps = peers
+		return nil
+	}
+
+	var compact []byte
+	err := bencode.Unmarshal(data, &compact)
+	if err != nil {
+		return err
+	}
+	peers, err := ParseCompactPeers(compact)
+	if err != nil {
+		return err
+	}
+	*ps = peers
+	return nil
+}
+
+// ParseCompactPeers parses IPv4 peers in the compact format of BEP 23, six
+// bytes each, which is also how UDP trackers reached over IPv4 send them.
+func ParseCompactPeers(compact []byte) (Peers, error) {
+	if len(compact)%compactPeerLen != 0 {
+		return nil, fmt.Errorf("compact peers length %d is not a multiple of %d",
+			len(compact), compactPeerLen)
+	}
+	peers := make(Peers, 0, len(compact)/compactPeerLen)
+	for i := 0; i < len(compact); i += compactPeerLen {
+		peers = append(peers, peerFromBytes(compact[i:i+compactPeerLen]))
+	}
+	return peers, nil
+}
+
+// ParseCompactPeers6 parses IPv6 peers in the compact format of BEP 7,
+// eighteen bytes each. UDP trackers send peers this way when they are
+// reached over IPv6.
+func ParseCompactPeers6(compact []byte) (Peers, error) {
+	if len(compact)%compactPeer6Len != 0 {
+		return nil, fmt.Errorf("compact IPv6 peers length %d is not a multiple of %d",
+			len(compact), compactPeer6Len)
+	}
+	peers := make(Peers, 0, len(compact)/compactPeer6Len)
+	for i := 0; i < len(compact); i += compactPeer6Len {
+		b := compact[i : i+compactPeer6Len]
+		peers = append(peers, Peer{
+			IP:   net.IP(append([]byte(nil), b[:16]...)),
+			Port: uint16(b[16])<<8 | uint16(b[17]),
+		})
+	}
+	return peers, nil
+}
+
+func (ps Peers) MarshalBencode() ([]byte, error) {
+	compact := make([]byte, 0, len(ps)*compactPeerLen)
+	for _, p := range ps {
+		ip := p.IP.To4()
+		if ip == nil {
+			return nil, errors.New("compact peers support only IPv4 addresses")
+		}
+		compact = append(compact, ip...)
+		compact = append(compact, byte(p.Port>>8), byte(p.Port))
+	}
+	return bencode.Marshal(compact)
+}
+
+func peerFromBytes(b []byte) Peer {
+	return Peer{
+		IP:   net.IPv4(b[0], b[1], b[2], b[3]),
+		Port: uint16(b[4])<<8 | uint16(b[5]),
+	}
+}
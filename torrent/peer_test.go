@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+func TestUnmarshalPeers(t *testing.T) {
+	var testCases = []struct {
+		in  string
+		out Peers
+	}{
+		{"0:", Peers{}},
+		{
+			"12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50",
+			Peers{
+				{IP: net.IPv4(10, 0, 0, 1), Port: 6881},
+				{IP: net.IPv4(192, 168, 1, 2), Port: 80},
+			},
+		},
+		{
+			"ld2:ip8:10.0.0.17:peer id3:abc4:porti6881eed2:ip3:::14:porti1eee",
+			Peers{
+				{ID: []byte("abc"), IP: net.ParseIP("10.0.0.1"), Port: 6881},
+				{IP: net.ParseIP("::1"), Port: 1},
+			},
+		},
+	}
+	for _, tt := range testCases {
+		var res AnnounceResponse
+		err := bencode.Unmarshal([]byte("d5:peers"+tt.in+"e"), &res)
+		if err != nil {
+			t.Fatalf("Error while unmarshalling %q: %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(res.Peers, tt.out) {
+			t.Fatalf("Unmarshal %q err: wanted %v got %v", tt.in, tt.out, res.Peers)
+		}
+	}
+
+	var peers Peers
+	err := bencode.Unmarshal([]byte("5:abcde"), &peers)
+	if err == nil {
+		t.Fatal("expected error for truncated compact peers, got nil")
+	}
+}
+
+func TestParseCompactPeers6(t *testing.T) {
+	compact := append(net.ParseIP("2001:db8::1"), 0x1a, 0xe1)
+	peers, err := ParseCompactPeers6(compact)
+	if err != nil {
+		t.Fatalf("Error while parsing %x: %v", compact, err)
+	}
+	want := Peers{{IP: net.ParseIP("2001:db8::1"), Port: 6881}}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("wanted %v got %v", want, peers)
+	}
+	if _, err := ParseCompactPeers6(compact[:6]); err == nil {
+		t.Fatal("expected error for an IPv4 length, got nil")
+	}
+}
+
+func TestMarshalPeers(t *testing.T) {
+	peers := Peers{{IP: net.ParseIP("10.0.0.1"), Port: 6881}}
+	b, err := bencode.Marshal(peers)
+	if err != nil {
+		t.Fatalf("Error while marshalling %v: %v", peers, err)
+	}
+	if string(b) != "6:\x0a\x00\x00\x01\x1a\xe1" {
+		t.Fatalf("Marshal %v err: got %q", peers, b)
+	}
+
+	_, err = bencode.Marshal(Peers{{IP: net.ParseIP("::1")}})
+	if err == nil {
+		t.Fatal("expected error for IPv6 compact peer, got nil")
+	}
+}
@@ -1,23 +1,126 @@
 package torrent
 
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+)
+
+// Torrent is the content described by a MetaInfo: a sequence of files
+// which, concatenated in order, are split into pieces of equal length
+// except for the last one, which may be shorter. The MetaInfo should have
+// passed Validate, the methods taking piece and file indexes panic if they
+// are out of range.
 type Torrent struct {
 	metaInfo MetaInfo
+	files    []torrentFile
 
 	Length int
 }
 
+type torrentFile struct {
+	offset int64 // offset of the file in the torrent
+	length int64
+}
+
 func NewTorrent(mi MetaInfo) *Torrent {
-	var length int
+	var files []torrentFile
+	var length int64
 	if mi.Info.Files == nil {
 		// single file torrent
-		length = mi.Info.Length
+		length = int64(mi.Info.Length)
+		files = []torrentFile{{length: length}}
 	} else {
 		for _, f := range mi.Info.Files {
-			length += f.Length
+			files = append(files, torrentFile{offset: length, length: int64(f.Length)})
+			length += int64(f.Length)
 		}
 	}
 	return &Torrent{
 		metaInfo: mi,
-		Length:   length,
+		files:    files,
+		Length:   int(length),
+	}
+}
+
+// NumPieces returns the number of pieces in the torrent.
+func (t *Torrent) NumPieces() int {
+	return len(t.metaInfo.Info.Pieces) / sha1.Size
+}
+
+// PieceHash returns the SHA-1 hash of piece i. The result aliases the
+// MetaInfo and must not be modified.
+func (t *Torrent) PieceHash(i int) []byte {
+	t.checkPiece(i)
+	start, end := i*sha1.Size, (i+1)*sha1.Size
+	return t.metaInfo.Info.Pieces[start:end:end]
+}
+
+// PieceLength returns the length of piece i, which is the piece length of
+// the torrent for all but the last piece.
+func (t *Torrent) PieceLength(i int) int {
+	t.checkPiece(i)
+	pieceLength := t.metaInfo.Info.PieceLength
+	if rest := int64(t.Length) - t.pieceOffset(i); rest < int64(pieceLength) {
+		return int(rest)
+	}
+	return pieceLength
+}
+
+// FileRange is a part of a file.
+type FileRange struct {
+	File   int   // index of the file, always 0 in single file mode
+	Offset int64 // offset of the part in the file
+	Length int64
+}
+
+// PieceFileRanges returns the parts of files that piece i is made of, in
+// order. Empty files are not part of any piece.
+func (t *Torrent) PieceFileRanges(i int) []FileRange {
+	start := t.pieceOffset(i)
+	end := start + int64(t.PieceLength(i))
+
+	// the first file that ends after the piece starts
+	j := sort.Search(len(t.files), func(j int) bool {
+		return t.files[j].offset+t.files[j].length > start
+	})
+	var ranges []FileRange
+	for ; j < len(t.files) && t.files[j].offset < end; j++ {
+		f := t.files[j]
+		if f.length == 0 {
+			continue
+		}
+		s, e := f.offset, f.offset+f.length
+		if s < start {
+			s = start
+		}
+		if e > end {
+			e = end
+		}
+		ranges = append(ranges, FileRange{File: j, Offset: s - f.offset, Length: e - s})
+	}
+	return ranges
+}
+
+// FilePieceRange returns the range of pieces [begin, end) that file i is
+// stored in. The first and last of them may hold parts of other files
+// too. For an empty file begin equals end.
+func (t *Torrent) FilePieceRange(i int) (begin, end int) {
+	f := t.files[i]
+	pieceLength := int64(t.metaInfo.Info.PieceLength)
+	begin = int(f.offset / pieceLength)
+	if f.length == 0 {
+		return begin, begin
+	}
+	return begin, int((f.offset+f.length-1)/pieceLength) + 1
+}
+
+func (t *Torrent) pieceOffset(i int) int64 {
+	return int64(i) * int64(t.metaInfo.Info.PieceLength)
+}
+
+func (t *Torrent) checkPiece(i int) {
+	if i < 0 || i >= t.NumPieces() {
+		panic(fmt.Sprintf("torrent: piece index %d out of range [0, %d)", i, t.NumPieces()))
 	}
 }
@@ -0,0 +1,96 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"reflect"
+	"testing"
+)
+
+func testPieces(n int) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, bytes.Repeat([]byte{byte(i)}, sha1.Size)...)
+	}
+	return b
+}
+
+func TestTorrentPieces(t *testing.T) {
+	tor := NewTorrent(MetaInfo{Info: InfoDict{
+		PieceLength: 4,
+		Pieces:      testPieces(3),
+		Name:        "d",
+		Files: []FileDict{
+			{Length: 3, Path: []string{"a"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 6, Path: []string{"b"}},
+			{Length: 2, Path: []string{"c"}},
+		},
+	}})
+	if tor.Length != 11 {
+		t.Fatalf("wrong length %d", tor.Length)
+	}
+	if n := tor.NumPieces(); n != 3 {
+		t.Fatalf("wrong number of pieces %d", n)
+	}
+
+	var pieceCases = []struct {
+		length int
+		ranges []FileRange
+	}{
+		{4, []FileRange{{File: 0, Offset: 0, Length: 3}, {File: 2, Offset: 0, Length: 1}}},
+		{4, []FileRange{{File: 2, Offset: 1, Length: 4}}},
+		{3, []FileRange{{File: 2, Offset: 5, Length: 1}, {File: 3, Offset: 0, Length: 2}}},
+	}
+	for i, pc := range pieceCases {
+		if !bytes.Equal(tor.PieceHash(i), bytes.Repeat([]byte{byte(i)}, sha1.Size)) {
+			t.Fatalf("piece %d: wrong hash %x", i, tor.PieceHash(i))
+		}
+		if l := tor.PieceLength(i); l != pc.length {
+			t.Fatalf("piece %d: wanted length %d got %d", i, pc.length, l)
+		}
+		if r := tor.PieceFileRanges(i); !reflect.DeepEqual(r, pc.ranges) {
+			t.Fatalf("piece %d: wanted ranges %v got %v", i, pc.ranges, r)
+		}
+	}
+
+	var fileCases = []struct {
+		begin, end int
+	}{
+		{0, 1},
+		{0, 0},
+		{0, 3},
+		{2, 3},
+	}
+	for i, fc := range fileCases {
+		if begin, end := tor.FilePieceRange(i); begin != fc.begin || end != fc.end {
+			t.Fatalf("file %d: wanted pieces [%d, %d) got [%d, %d)", i, fc.begin, fc.end, begin, end)
+		}
+	}
+}
+
+func TestTorrentSingleFile(t *testing.T) {
+	tor := NewTorrent(MetaInfo{Info: InfoDict{
+		PieceLength: 4,
+		Pieces:      testPieces(2),
+		Name:        "a",
+		Length:      8,
+	}})
+	if l := tor.PieceLength(1); l != 4 {
+		t.Fatalf("wanted full last piece, got length %d", l)
+	}
+	want := []FileRange{{File: 0, Offset: 4, Length: 4}}
+	if r := tor.PieceFileRanges(1); !reflect.DeepEqual(r, want) {
+		t.Fatalf("wanted ranges %v got %v", want, r)
+	}
+	if begin, end := tor.FilePieceRange(0); begin != 0 || end != 2 {
+		t.Fatalf("wanted pieces [0, 2) got [%d, %d)", begin, end)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for piece index out of range")
+		}
+	}()
+	tor.PieceLength(2)
+}
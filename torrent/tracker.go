@@ -22,11 +22,13 @@ const (
 )
 
 type AnnounceResponse struct {
-	FailureReason  string      `bencode:"failure reason"`
-	WarningMessage string      `bencode:"warning message"`
-	Interval       int         `bencode:"interval"`
-	TrackerID      string      `bencode:"tracker id"`
-	Complete       int         `bencode:"complete"`
-	Incomplete     int         `bencode:"complete"`
-	Peers          interface{} `bencode:"peers"`
+	FailureReason  string `bencode:"failure reason"`
+	WarningMessage string `bencode:"warning message"`
+	// Interval and MinInterval are in seconds
+	Interval    int    `bencode:"interval"`
+	MinInterval int    `bencode:"min interval"`
+	TrackerID   string `bencode:"tracker id"`
+	Complete    int    `bencode:"complete"`
+	Incomplete  int    `bencode:"incomplete"`
+	Peers       Peers  `bencode:"peers"`
 }
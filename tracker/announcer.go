@@ -0,0 +1,176 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+const (
+	// DefaultInterval is how often to announce when a tracker doesn't say.
+	DefaultInterval = 30 * time.Minute
+	// DefaultRetryInterval is how long to wait after all trackers failed.
+	DefaultRetryInterval = time.Minute
+	// DefaultMinInterval is how long after an announce Wake can cause the
+	// next one, when the tracker doesn't give a min interval.
+	DefaultMinInterval = time.Minute
+	// stopTimeout bounds the stopped announce sent when Run returns.
+	stopTimeout = 10 * time.Second
+)
+
+// An Announcer announces a torrent to its trackers, with a Client for each
+// one so that tracker ids are kept between announces.
+type Announcer struct {
+	Tiers *Tiers
+	// HTTPClient is passed on to the Clients.
+	HTTPClient *http.Client
+	// RetryInterval is how long Run waits after all trackers failed,
+	// DefaultRetryInterval if it is zero.
+	RetryInterval time.Duration
+	// MinInterval is how soon after an announce Wake can cause another,
+	// DefaultMinInterval if it is zero. A longer min interval from the
+	// tracker takes precedence.
+	MinInterval time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*Client
+	wake    chan struct{}
+}
+
+func NewAnnouncer(tiers *Tiers) *Announcer {
+	return &Announcer{Tiers: tiers}
+}
+
+// Announce sends req to the first tracker that responds, see Tiers.
+func (a *Announcer) Announce(ctx context.Context, req torrent.AnnounceRequest) (*torrent.AnnounceResponse, error) {
+	resp, _, err := a.Tiers.Announce(ctx, func(ctx context.Context, url string) (*torrent.AnnounceResponse, error) {
+		return a.client(url).Announce(ctx, req)
+	})
+	return resp, err
+}
+
+func (a *Announcer) client(url string) *Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clients == nil {
+		a.clients = make(map[string]*Client)
+	}
+	c, ok := a.clients[url]
+	if !ok {
+		c = &Client{URL: url, HTTPClient: a.HTTPClient}
+		a.clients[url] = c
+	}
+	return c
+}
+
+// Run announces until ctx is done, calling state for the request to send
+// and handle with each response, or with the error when no tracker
+// responded, in which case Run tries again after RetryInterval. The first
+// announce is a started event, after that there is no event unless state
+// sets one, and the next announce is after the interval the tracker asked
+// for, or sooner if Wake is called. When ctx is done a stopped event is sent, if the started one went
+// through.
+func (a *Announcer) Run(ctx context.Context, state func() torrent.AnnounceRequest, handle func(*torrent.AnnounceResponse, error)) {
+	started := false
+	for {
+		req := state()
+		if !started {
+			req.Event = torrent.EventStarted
+		}
+		wait := a.RetryInterval
+		if wait == 0 {
+			wait = DefaultRetryInterval
+		}
+		minWait := a.MinInterval
+		if minWait == 0 {
+			minWait = DefaultMinInterval
+		}
+		// a Wake before the announce is answered by it
+		select {
+		case <-a.wakeChan():
+		default:
+		}
+		last := time.Now()
+		resp, err := a.Announce(ctx, req)
+		switch {
+		case err == nil:
+			started = true
+			handle(resp, nil)
+			wait = announceInterval(resp)
+			if min := time.Duration(resp.MinInterval) * time.Second; minWait < min {
+				minWait = min
+			}
+		case ctx.Err() == nil:
+			handle(nil, err)
+		}
+
+		if !a.wait(ctx, last, wait, minWait) {
+			if started {
+				a.stop(state())
+			}
+			return
+		}
+	}
+}
+
+// Wake asks Run to announce before the tracker's interval is up, because
+// more peers are needed. The announce is made once MinInterval has passed
+// since the last one.
+func (a *Announcer) Wake() {
+	select {
+	case a.wakeChan() <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Announcer) wakeChan() chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.wake == nil {
+		a.wake = make(chan struct{}, 1)
+	}
+	return a.wake
+}
+
+// wait waits until wait has passed since last, or minWait if Wake is
+// called meanwhile. It returns false if ctx is done first.
+func (a *Announcer) wait(ctx context.Context, last time.Time, wait, minWait time.Duration) bool {
+	next := last.Add(wait)
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+			return true
+		case <-a.wakeChan():
+			timer.Stop()
+			if early := last.Add(minWait); early.Before(next) {
+				next = early
+			}
+		}
+	}
+}
+
+func (a *Announcer) stop(req torrent.AnnounceRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	req.Event = torrent.EventStopped
+	a.Announce(ctx, req)
+}
+
+// announceInterval is how long to wait before announcing again after resp.
+func announceInterval(resp *torrent.AnnounceResponse) time.Duration {
+	interval := time.Duration(resp.Interval) * time.Second
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if min := time.Duration(resp.MinInterval) * time.Second; interval < min {
+		interval = min
+	}
+	return interval
+}
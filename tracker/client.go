@@ -0,0 +1,106 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+// DefaultTimeout bounds a single announce made by a Client without an
+// HTTPClient of its own.
+const DefaultTimeout = 30 * time.Second
+
+// maxRedirects is how many redirects an HTTP announce follows.
+const maxRedirects = 5
+
+var defaultHTTPClient = &http.Client{
+	Timeout:       DefaultTimeout,
+	CheckRedirect: checkRedirect,
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported protocol %q", req.URL.Scheme)
+	}
+	return nil
+}
+
+// A FailureError is a tracker's refusal to handle an announce.
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// A Client announces to a single tracker. It remembers the tracker id the
+// tracker hands out and sends it back in later announces.
+type Client struct {
+	URL string
+	// HTTPClient is used for HTTP trackers. If it is nil a client with
+	// DefaultTimeout that follows a few redirects is used.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	trackerID string
+	udp       udpState
+}
+
+func NewClient(url string) *Client {
+	return &Client{URL: url}
+}
+
+// Announce sends req to the tracker. A response with a failure reason is
+// returned as a *FailureError, a warning message is left in the response
+// for the caller to report.
+func (c *Client) Announce(ctx context.Context, req torrent.AnnounceRequest) (*torrent.AnnounceResponse, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if req.TrackerID == "" {
+		req.TrackerID = c.trackerID
+	}
+	c.mu.Unlock()
+
+	var resp *torrent.AnnounceResponse
+	switch u.Scheme {
+	case "http", "https":
+		resp, err = c.announceHTTP(ctx, u, req)
+	case "udp":
+		resp, err = c.announceUDP(ctx, u, req)
+	default:
+		return nil, fmt.Errorf("unsupported tracker protocol %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.FailureReason != "" {
+		return nil, &FailureError{resp.FailureReason}
+	}
+
+	if resp.TrackerID != "" {
+		c.mu.Lock()
+		c.trackerID = resp.TrackerID
+		c.mu.Unlock()
+	}
+	return resp, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
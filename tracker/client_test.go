@@ -0,0 +1,317 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+func testServer(t *testing.T, handler http.HandlerFunc) string {
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	return s.URL + "/announce"
+}
+
+func TestClientAnnounce(t *testing.T) {
+	var query url.Values
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("d8:completei5e10:incompletei3e8:intervali1800e12:min intervali900e" +
+			"5:peers6:\x0a\x00\x00\x01\x1a\xe1" +
+			"15:warning message4:slow10:tracker id3:abce"))
+	})
+
+	req := torrent.AnnounceRequest{
+		Port:    6881,
+		Left:    100,
+		Event:   torrent.EventStarted,
+		NumWant: 50,
+	}
+	copy(req.InfoHash[:], "\x00\x01\x02 &=?\xff")
+	copy(req.PeerID[:], "-GT0001-abcdefghijkl")
+	c := NewClient(u + "?key=k")
+	resp, err := c.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+
+	want := &torrent.AnnounceResponse{
+		WarningMessage: "slow",
+		Interval:       1800,
+		MinInterval:    900,
+		TrackerID:      "abc",
+		Complete:       5,
+		Incomplete:     3,
+		Peers:          torrent.Peers{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("wanted %+v got %+v", want, resp)
+	}
+	wantQuery := url.Values{
+		"info_hash":  {string(req.InfoHash[:])},
+		"peer_id":    {"-GT0001-abcdefghijkl"},
+		"port":       {"6881"},
+		"uploaded":   {"0"},
+		"downloaded": {"0"},
+		"left":       {"100"},
+		"compact":    {"1"},
+		"event":      {"started"},
+		"numwant":    {"50"},
+		"key":        {"k"},
+	}
+	if !reflect.DeepEqual(query, wantQuery) {
+		t.Fatalf("wanted query %v got %v", wantQuery, query)
+	}
+
+	// the tracker id is sent back, and no event for regular announces
+	req.Event = torrent.EventEmpty
+	if _, err := c.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if query.Get("trackerid") != "abc" || query["event"] != nil {
+		t.Fatalf("wrong query %v", query)
+	}
+}
+
+func TestClientDictPeers(t *testing.T) {
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peersld2:ip8:10.0.0.17:peer id3:abc4:porti6881eeee"))
+	})
+	resp, err := NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := torrent.Peers{{ID: []byte("abc"), IP: net.ParseIP("10.0.0.1"), Port: 6881}}
+	if !reflect.DeepEqual(resp.Peers, want) {
+		t.Fatalf("wanted peers %v got %v", want, resp.Peers)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	var testCases = []struct {
+		handler     http.HandlerFunc
+		errContains string
+	}{
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("d14:failure reason9:not found8:intervali60ee"))
+			},
+			"tracker failure: not found",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("d14:failure reason11:bad requeste"))
+			},
+			"tracker failure: bad request",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "<html>", http.StatusNotFound)
+			},
+			"404 Not Found",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html>"))
+			},
+			"invalid tracker response",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, r.URL.Path+"?"+r.URL.RawQuery, http.StatusFound)
+			},
+			"stopped after 5 redirects",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "udp://tracker.example:80/", http.StatusFound)
+			},
+			`redirect to unsupported protocol "udp"`,
+		},
+	}
+	for _, tc := range testCases {
+		u := testServer(t, tc.handler)
+		_, err := NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{})
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Fatalf("expected error containing %q, got %v", tc.errContains, err)
+		}
+	}
+
+	u := testServer(t, failingTracker)
+	_, err := NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{})
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "unregistered" {
+		t.Fatalf("expected FailureError, got %v", err)
+	}
+
+	_, err = NewClient("ftp://tracker.example/").Announce(context.Background(), torrent.AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported tracker protocol") {
+		t.Fatalf("expected unsupported protocol error, got %v", err)
+	}
+}
+
+func TestClientRedirect(t *testing.T) {
+	target := testServer(t, okTracker(""))
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target+"?"+r.URL.RawQuery, http.StatusFound)
+	})
+	resp, err := NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if resp.Interval != 1800 {
+		t.Fatalf("wrong response %+v", resp)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+
+	c := &Client{URL: u, HTTPClient: &http.Client{Timeout: 50 * time.Millisecond}}
+	if _, err := c.Announce(context.Background(), torrent.AnnounceRequest{}); err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewClient(u).Announce(ctx, torrent.AnnounceRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestAnnouncerRun(t *testing.T) {
+	var mu sync.Mutex
+	var events, trackerIDs []string
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		events = append(events, q.Get("event"))
+		trackerIDs = append(trackerIDs, q.Get("trackerid"))
+		if len(events) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("d8:intervali1e10:tracker id3:abc5:peers0:e"))
+	})
+
+	a := NewAnnouncer(&Tiers{tiers: [][]string{{u}}})
+	a.RetryInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var responses int
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx,
+			func() torrent.AnnounceRequest { return torrent.AnnounceRequest{Left: 1} },
+			func(resp *torrent.AnnounceResponse, err error) {
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
+				responses++
+				if responses == 2 {
+					cancel()
+				}
+			})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "503") {
+		t.Fatalf("wanted the error of the first announce, got %v", errs)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"started", "started", "", "stopped"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("wanted events %q got %q", want, events)
+	}
+	if want := []string{"", "", "abc", "abc"}; !reflect.DeepEqual(trackerIDs, want) {
+		t.Fatalf("wanted tracker ids %q got %q", want, trackerIDs)
+	}
+}
+
+func TestAnnouncerWake(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Write([]byte("d8:intervali3600e5:peers0:e"))
+	})
+
+	a := NewAnnouncer(&Tiers{tiers: [][]string{{u}}})
+	a.MinInterval = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	responses := make(chan struct{}, 3)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx,
+			func() torrent.AnnounceRequest { return torrent.AnnounceRequest{} },
+			func(resp *torrent.AnnounceResponse, err error) { responses <- struct{}{} })
+		close(done)
+	}()
+	// an early announce, no sooner than MinInterval after the first
+	for i := 0; i < 2; i++ {
+		select {
+		case <-responses:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no announce %d", i+1)
+		}
+		a.Wake()
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	// and the stopped announce
+	if len(times) != 3 {
+		t.Fatalf("wanted 3 announces, got %d", len(times))
+	}
+	// the times are taken by the server, allow for the time a request takes
+	if d := times[1].Sub(times[0]); d < 80*time.Millisecond {
+		t.Fatalf("woken announce after %v, before the min interval", d)
+	}
+}
+
+func TestAnnounceInterval(t *testing.T) {
+	var testCases = []struct {
+		interval, minInterval int
+		out                   time.Duration
+	}{
+		{1800, 0, 30 * time.Minute},
+		{0, 0, DefaultInterval},
+		{60, 120, 2 * time.Minute},
+		{120, 60, 2 * time.Minute},
+	}
+	for _, tc := range testCases {
+		resp := &torrent.AnnounceResponse{Interval: tc.interval, MinInterval: tc.minInterval}
+		if out := announceInterval(resp); out != tc.out {
+			t.Fatalf("interval %d, min %d: wanted %v got %v", tc.interval, tc.minInterval, tc.out, out)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/filipochnik/btget/bencode"
+	"github.com/filipochnik/btget/torrent"
+)
+
+// maxResponseSize bounds how much of a tracker's response is read.
+const maxResponseSize = 1 << 20
+
+func (c *Client) announceHTTP(ctx context.Context, u *url.URL, req torrent.AnnounceRequest) (*torrent.AnnounceResponse, error) {
+	q := u.Query()
+	q.Set("info_hash", string(req.InfoHash[:]))
+	q.Set("peer_id", string(req.PeerID[:]))
+	q.Set("port", strconv.Itoa(req.Port))
+	q.Set("uploaded", strconv.Itoa(req.Uploaded))
+	q.Set("downloaded", strconv.Itoa(req.Downloaded))
+	q.Set("left", strconv.Itoa(req.Left))
+	q.Set("compact", "1")
+	if req.Event != "" && req.Event != torrent.EventEmpty {
+		q.Set("event", string(req.Event))
+	}
+	if req.NumWant > 0 {
+		q.Set("numwant", strconv.Itoa(req.NumWant))
+	}
+	if req.TrackerID != "" {
+		q.Set("trackerid", req.TrackerID)
+	}
+	announceURL := *u
+	announceURL.RawQuery = q.Encode()
+
+	status, b, err := c.getHTTP(ctx, &announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var announce torrent.AnnounceResponse
+	err = bencode.Unmarshal(b, &announce)
+	if status != http.StatusOK {
+		// some trackers explain errors with a failure reason
+		if err == nil && announce.FailureReason != "" {
+			return &announce, nil
+		}
+		return nil, fmt.Errorf("tracker returned %d %s", status, http.StatusText(status))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker response: %v", err)
+	}
+	return &announce, nil
+}
+
+// getHTTP fetches u and returns the status code and at most
+// maxResponseSize bytes of the body.
+func (c *Client) getHTTP(ctx context.Context, u *url.URL) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, b, nil
+}
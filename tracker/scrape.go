@@ -0,0 +1,126 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/filipochnik/btget/bencode"
+)
+
+// ErrScrapeUnsupported is returned for trackers whose announce URL doesn't
+// follow the convention a scrape URL is derived from.
+var ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+
+// ScrapeStats are a tracker's counts of the peers of a torrent.
+type ScrapeStats struct {
+	Complete   int `bencode:"complete"`   // seeders
+	Downloaded int `bencode:"downloaded"` // completed downloads
+	Incomplete int `bencode:"incomplete"` // leechers
+}
+
+type scrapeResponse struct {
+	FailureReason string                 `bencode:"failure reason"`
+	Files         map[string]ScrapeStats `bencode:"files"`
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker from its announce
+// URL: the last path element must start with "announce", which is replaced
+// with "scrape". UDP trackers scrape at their announce URL.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "udp" {
+		return announce, nil
+	}
+	i := strings.LastIndexByte(u.Path, '/')
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", ErrScrapeUnsupported
+	}
+	u.Path = u.Path[:i+1] + "scrape" + u.Path[i+1+len("announce"):]
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Scrape asks the tracker for the stats of the torrents with infoHashes.
+// Torrents an HTTP tracker doesn't know are left out of the result, UDP
+// trackers report zeros for them. UDP trackers are asked in batches of as
+// many torrents as fit in a packet.
+func (c *Client) Scrape(ctx context.Context, infoHashes ...[20]byte) (map[[20]byte]ScrapeStats, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return c.scrapeHTTP(ctx, infoHashes)
+	case "udp":
+	default:
+		return nil, fmt.Errorf("unsupported tracker protocol %q", u.Scheme)
+	}
+
+	stats := make(map[[20]byte]ScrapeStats, len(infoHashes))
+	for len(infoHashes) > 0 {
+		batch := infoHashes
+		if len(batch) > udpMaxScrape {
+			batch = batch[:udpMaxScrape]
+		}
+		infoHashes = infoHashes[len(batch):]
+		s, err := c.scrapeUDP(ctx, u, batch)
+		if err != nil {
+			return nil, err
+		}
+		for i, h := range batch {
+			stats[h] = s[i]
+		}
+	}
+	return stats, nil
+}
+
+func (c *Client) scrapeHTTP(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	scrape, err := ScrapeURL(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(scrape)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for _, h := range infoHashes {
+		q.Add("info_hash", string(h[:]))
+	}
+	u.RawQuery = q.Encode()
+
+	status, b, err := c.getHTTP(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var resp scrapeResponse
+	err = bencode.Unmarshal(b, &resp)
+	if err == nil && resp.FailureReason != "" {
+		return nil, &FailureError{resp.FailureReason}
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("tracker returned %d %s", status, http.StatusText(status))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid scrape response: %v", err)
+	}
+
+	stats := make(map[[20]byte]ScrapeStats, len(resp.Files))
+	for k, s := range resp.Files {
+		var h [20]byte
+		if len(k) != len(h) {
+			return nil, fmt.Errorf("invalid info hash %x in scrape response", k)
+		}
+		copy(h[:], k)
+		stats[h] = s
+	}
+	return stats, nil
+}
@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScrapeURL(t *testing.T) {
+	var testCases = []struct {
+		in, out string
+	}{
+		{"http://example.com/announce", "http://example.com/scrape"},
+		{"http://example.com/x/announce", "http://example.com/x/scrape"},
+		{"http://example.com/announce.php", "http://example.com/scrape.php"},
+		{"http://example.com/announce?passkey=a%2Fb", "http://example.com/scrape?passkey=a%2Fb"},
+		{"https://example.com/announce%20x", "https://example.com/scrape%20x"},
+		{"udp://example.com:80/announce", "udp://example.com:80/announce"},
+		{"udp://example.com:80", "udp://example.com:80"},
+		{"http://example.com/a", ""},
+		{"http://example.com/announce?x=2/4", "http://example.com/scrape?x=2/4"},
+		{"http://example.com/x%20announce", ""},
+		{"http://example.com/announce/x", ""},
+		{"http://example.com", ""},
+	}
+	for _, tc := range testCases {
+		out, err := ScrapeURL(tc.in)
+		if tc.out == "" {
+			if err != ErrScrapeUnsupported {
+				t.Fatalf("%s: expected ErrScrapeUnsupported, got %q, %v", tc.in, out, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: ScrapeURL failed: %v", tc.in, err)
+		}
+		if out != tc.out {
+			t.Fatalf("%s: wanted %s got %s", tc.in, tc.out, out)
+		}
+	}
+}
+
+func TestScrapeHTTP(t *testing.T) {
+	var path string
+	var hashes []string
+	u := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		hashes = r.URL.Query()["info_hash"]
+		w.Write([]byte("d5:filesd" +
+			"20:aaaaaaaaaaaaaaaaaaaad8:completei5e10:downloadedi50e10:incompletei10ee" +
+			"20:bbbbbbbbbbbbbbbbbbbbd8:completei1e10:downloadedi2e10:incompletei3ee" +
+			"ee"))
+	})
+	var a, b, c [20]byte
+	copy(a[:], "aaaaaaaaaaaaaaaaaaaa")
+	copy(b[:], "bbbbbbbbbbbbbbbbbbbb")
+	copy(c[:], "cccccccccccccccccccc")
+
+	stats, err := NewClient(u).Scrape(context.Background(), a, b, c)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	want := map[[20]byte]ScrapeStats{
+		a: {Complete: 5, Downloaded: 50, Incomplete: 10},
+		b: {Complete: 1, Downloaded: 2, Incomplete: 3},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("wanted %v got %v", want, stats)
+	}
+	if path != "/scrape" || !reflect.DeepEqual(hashes, []string{string(a[:]), string(b[:]), string(c[:])}) {
+		t.Fatalf("wrong request to %s for %q", path, hashes)
+	}
+}
+
+func TestScrapeErrors(t *testing.T) {
+	var testCases = []struct {
+		handler     http.HandlerFunc
+		errContains string
+	}{
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("d14:failure reason9:forbiddene"))
+			},
+			"tracker failure: forbidden",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "<html>", http.StatusNotFound)
+			},
+			"404 Not Found",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html>"))
+			},
+			"invalid scrape response",
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("d5:filesd3:abcd8:completei1eeee"))
+			},
+			"invalid info hash 616263",
+		},
+	}
+	for _, tc := range testCases {
+		u := testServer(t, tc.handler)
+		_, err := NewClient(u).Scrape(context.Background(), [20]byte{})
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Fatalf("expected error containing %q, got %v", tc.errContains, err)
+		}
+	}
+
+	_, err := NewClient("http://tracker.example/tracker").Scrape(context.Background(), [20]byte{})
+	if !errors.Is(err, ErrScrapeUnsupported) {
+		t.Fatalf("expected ErrScrapeUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package tracker talks to BitTorrent trackers.
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+// Tiers are the trackers of a torrent, grouped into tiers as BEP 12
+// describes. Trackers are tried tier by tier, and in order within a tier,
+// until one responds. That tracker then moves to the front of its tier so
+// that it is tried first next time. Each tier starts out shuffled to spread
+// the load between trackers that are meant to be equivalent.
+type Tiers struct {
+	mu    sync.Mutex
+	tiers [][]string
+}
+
+// NewTiers returns the tiers of m. The announce URL is only used if the
+// torrent has no announce list, which then takes its place.
+func NewTiers(m *torrent.MetaInfo) *Tiers {
+	var tiers [][]string
+	for _, tier := range m.AnnounceList {
+		var urls []string
+		for _, url := range tier {
+			if url != "" {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		rand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+		tiers = append(tiers, urls)
+	}
+	if len(tiers) == 0 && m.Announce != "" {
+		tiers = [][]string{{m.Announce}}
+	}
+	return &Tiers{tiers: tiers}
+}
+
+// URLs returns a copy of the tiers in the order they will be tried.
+func (t *Tiers) URLs() [][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tiers := make([][]string, len(t.tiers))
+	for i, tier := range t.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// An AnnounceFunc announces to the tracker at url.
+type AnnounceFunc func(ctx context.Context, url string) (*torrent.AnnounceResponse, error)
+
+// Announce calls announce for each tracker in turn until one succeeds and
+// returns its response along with the tracker's URL. If they all fail the
+// error lists why.
+func (t *Tiers) Announce(ctx context.Context, announce AnnounceFunc) (*torrent.AnnounceResponse, string, error) {
+	tiers := t.URLs()
+	if len(tiers) == 0 {
+		return nil, "", errors.New("torrent has no trackers")
+	}
+	var errs []string
+	for i, tier := range tiers {
+		for _, url := range tier {
+			resp, err := announce(ctx, url)
+			if err == nil {
+				t.promote(i, url)
+				return resp, url, nil
+			}
+			if ctx.Err() != nil {
+				return nil, "", ctx.Err()
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+		}
+	}
+	return nil, "", fmt.Errorf("all trackers failed:\n\t%s", strings.Join(errs, "\n\t"))
+}
+
+// promote moves url to the front of tier i.
+func (t *Tiers) promote(i int, url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tier := t.tiers[i]
+	for j, u := range tier {
+		if u == url {
+			copy(tier[1:j+1], tier[:j])
+			tier[0] = url
+			return
+		}
+	}
+}
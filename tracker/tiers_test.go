@@ -0,0 +1,180 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/filipochnik/btget/bencode"
+	"github.com/filipochnik/btget/torrent"
+)
+
+// testTrackers runs local trackers and records the order they are asked in.
+type testTrackers struct {
+	mu    sync.Mutex
+	asked []string
+}
+
+func (tt *testTrackers) start(t *testing.T, name string, handler http.HandlerFunc) string {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tt.mu.Lock()
+		tt.asked = append(tt.asked, name)
+		tt.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s.URL + "/announce"
+}
+
+func (tt *testTrackers) reset() []string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	asked := tt.asked
+	tt.asked = nil
+	return asked
+}
+
+func okTracker(peers string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, _ := bencode.Marshal(map[string]interface{}{
+			"interval": 1800,
+			"peers":    peers,
+		})
+		w.Write(b)
+	}
+}
+
+func failingTracker(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("d14:failure reason12:unregisterede"))
+}
+
+func brokenTracker(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "oops", http.StatusInternalServerError)
+}
+
+// downTracker returns the URL of a tracker that refuses connections.
+func downTracker(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return "http://" + l.Addr().String() + "/announce"
+}
+
+func announceHTTP(ctx context.Context, url string) (*torrent.AnnounceResponse, error) {
+	return NewClient(url).Announce(ctx, torrent.AnnounceRequest{Port: 6881, Left: 1})
+}
+
+func TestTiersFallback(t *testing.T) {
+	var tt testTrackers
+	down := downTracker(t)
+	failing := tt.start(t, "failing", failingTracker)
+	broken := tt.start(t, "broken", brokenTracker)
+	good := tt.start(t, "good", okTracker("\x0a\x00\x00\x01\x1a\xe1"))
+	other := tt.start(t, "other", okTracker(""))
+
+	tiers := &Tiers{tiers: [][]string{{down, failing, broken}, {good, other}}}
+	resp, url, err := tiers.Announce(context.Background(), announceHTTP)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if url != good || len(resp.Peers) != 1 || resp.Interval != 1800 {
+		t.Fatalf("wrong response from %s: %+v", url, resp)
+	}
+	if asked := tt.reset(); !reflect.DeepEqual(asked, []string{"failing", "broken", "good"}) {
+		t.Fatalf("trackers asked in the wrong order: %v", asked)
+	}
+	want := [][]string{{down, failing, broken}, {good, other}}
+	if urls := tiers.URLs(); !reflect.DeepEqual(urls, want) {
+		t.Fatalf("wanted tiers %v got %v", want, urls)
+	}
+}
+
+func TestTiersPromote(t *testing.T) {
+	var tt testTrackers
+	down := downTracker(t)
+	broken := tt.start(t, "broken", brokenTracker)
+	good := tt.start(t, "good", okTracker(""))
+	other := tt.start(t, "other", okTracker(""))
+
+	tiers := &Tiers{tiers: [][]string{{down, broken, good, other}}}
+	if _, _, err := tiers.Announce(context.Background(), announceHTTP); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := [][]string{{good, down, broken, other}}
+	if urls := tiers.URLs(); !reflect.DeepEqual(urls, want) {
+		t.Fatalf("wanted tiers %v got %v", want, urls)
+	}
+	tt.reset()
+
+	// the tracker that responded is now asked first
+	if _, url, err := tiers.Announce(context.Background(), announceHTTP); err != nil || url != good {
+		t.Fatalf("Announce: got %s, %v", url, err)
+	}
+	if asked := tt.reset(); !reflect.DeepEqual(asked, []string{"good"}) {
+		t.Fatalf("trackers asked in the wrong order: %v", asked)
+	}
+}
+
+func TestTiersAllFail(t *testing.T) {
+	var tt testTrackers
+	failing := tt.start(t, "failing", failingTracker)
+	tiers := &Tiers{tiers: [][]string{{failing}, {"wss://tracker.example/"}}}
+	_, _, err := tiers.Announce(context.Background(), announceHTTP)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	for _, s := range []string{"unregistered", `unsupported tracker protocol "wss"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected error containing %q, got %v", s, err)
+		}
+	}
+
+	_, _, err = (&Tiers{}).Announce(context.Background(), announceHTTP)
+	if err == nil || !strings.Contains(err.Error(), "no trackers") {
+		t.Fatalf("expected no trackers error, got %v", err)
+	}
+}
+
+func TestTiersCanceled(t *testing.T) {
+	var tt testTrackers
+	good := tt.start(t, "good", okTracker(""))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tiers := &Tiers{tiers: [][]string{{good}, {good}}}
+	if _, _, err := tiers.Announce(ctx, announceHTTP); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if asked := tt.reset(); len(asked) != 0 {
+		t.Fatalf("trackers asked after cancel: %v", asked)
+	}
+}
+
+func TestNewTiers(t *testing.T) {
+	m := &torrent.MetaInfo{
+		Announce:     "http://a/",
+		AnnounceList: [][]string{{"http://b/", "http://c/", "http://d/"}, {}, {"", "http://e/"}},
+	}
+	urls := NewTiers(m).URLs()
+	if len(urls) != 2 {
+		t.Fatalf("wanted 2 tiers, got %v", urls)
+	}
+	first := append([]string(nil), urls[0]...)
+	sort.Strings(first)
+	if !reflect.DeepEqual(first, []string{"http://b/", "http://c/", "http://d/"}) ||
+		!reflect.DeepEqual(urls[1], []string{"http://e/"}) {
+		t.Fatalf("wrong tiers %v", urls)
+	}
+
+	urls = NewTiers(&torrent.MetaInfo{Announce: "http://a/"}).URLs()
+	if !reflect.DeepEqual(urls, [][]string{{"http://a/"}}) {
+		t.Fatalf("wrong tiers %v", urls)
+	}
+}
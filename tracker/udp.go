@@ -0,0 +1,262 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+// UDP tracker protocol, BEP 15.
+
+const (
+	udpProtocolID = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionScrape   = 2
+	udpActionError    = 3
+
+	// a request is sent again after udpTimeout·2^n, up to n = udpMaxRetries
+	udpMaxRetries = 8
+
+	// the most info hashes that fit in a single scrape
+	udpMaxScrape = 74
+
+	maxUDPPacket = 2048
+)
+
+// Variables so that tests don't have to wait for as long.
+var (
+	udpTimeout = 15 * time.Second
+	// a connection ID can be used for this long after it was received
+	udpConnectionIDLifetime = time.Minute
+)
+
+var udpEvents = map[torrent.AnnounceEvent]uint32{
+	torrent.EventCompleted: 1,
+	torrent.EventStarted:   2,
+	torrent.EventStopped:   3,
+}
+
+// udpState is what a Client keeps between requests to a UDP tracker.
+type udpState struct {
+	connectionID uint64
+	expires      time.Time
+	key          uint32
+}
+
+func (c *Client) announceUDP(ctx context.Context, u *url.URL, req torrent.AnnounceRequest) (*torrent.AnnounceResponse, error) {
+	conn, err := dialUDP(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c.mu.Lock()
+	if c.udp.key == 0 {
+		c.udp.key = rand.Uint32()
+	}
+	key := c.udp.key
+	c.mu.Unlock()
+
+	numWant := int32(-1)
+	if req.NumWant > 0 {
+		numWant = int32(req.NumWant)
+	}
+	body, err := c.udpRoundTrip(ctx, conn, udpActionAnnounce, true, func(b []byte) []byte {
+		b = binary.BigEndian.AppendUint64(b, 0) // connection ID
+		b = binary.BigEndian.AppendUint32(b, udpActionAnnounce)
+		b = binary.BigEndian.AppendUint32(b, 0) // transaction ID
+		b = append(b, req.InfoHash[:]...)
+		b = append(b, req.PeerID[:]...)
+		b = binary.BigEndian.AppendUint64(b, uint64(req.Downloaded))
+		b = binary.BigEndian.AppendUint64(b, uint64(req.Left))
+		b = binary.BigEndian.AppendUint64(b, uint64(req.Uploaded))
+		b = binary.BigEndian.AppendUint32(b, udpEvents[req.Event])
+		b = binary.BigEndian.AppendUint32(b, 0) // IP address, the sender's
+		b = binary.BigEndian.AppendUint32(b, key)
+		b = binary.BigEndian.AppendUint32(b, uint32(numWant))
+		return binary.BigEndian.AppendUint16(b, uint16(req.Port))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 12 {
+		return nil, fmt.Errorf("short UDP announce response of %d bytes", len(body)+8)
+	}
+	// the tracker sends peers of the address family it is reached over
+	parsePeers := torrent.ParseCompactPeers
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		parsePeers = torrent.ParseCompactPeers6
+	}
+	peers, err := parsePeers(body[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &torrent.AnnounceResponse{
+		Interval:   int(binary.BigEndian.Uint32(body[0:])),
+		Incomplete: int(binary.BigEndian.Uint32(body[4:])),
+		Complete:   int(binary.BigEndian.Uint32(body[8:])),
+		Peers:      peers,
+	}, nil
+}
+
+// scrapeUDP returns the stats of each of infoHashes, in order.
+func (c *Client) scrapeUDP(ctx context.Context, u *url.URL, infoHashes [][20]byte) ([]ScrapeStats, error) {
+	if len(infoHashes) > udpMaxScrape {
+		return nil, fmt.Errorf("cannot scrape more than %d torrents at once", udpMaxScrape)
+	}
+	conn, err := dialUDP(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	body, err := c.udpRoundTrip(ctx, conn, udpActionScrape, true, func(b []byte) []byte {
+		b = binary.BigEndian.AppendUint64(b, 0) // connection ID
+		b = binary.BigEndian.AppendUint32(b, udpActionScrape)
+		b = binary.BigEndian.AppendUint32(b, 0) // transaction ID
+		for _, h := range infoHashes {
+			b = append(b, h[:]...)
+		}
+		return b
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 12*len(infoHashes) {
+		return nil, fmt.Errorf("short UDP scrape response of %d bytes", len(body)+8)
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := range stats {
+		b := body[12*i:]
+		stats[i] = ScrapeStats{
+			Complete:   int(binary.BigEndian.Uint32(b[0:])),
+			Downloaded: int(binary.BigEndian.Uint32(b[4:])),
+			Incomplete: int(binary.BigEndian.Uint32(b[8:])),
+		}
+	}
+	return stats, nil
+}
+
+func dialUDP(ctx context.Context, u *url.URL) (net.Conn, error) {
+	if u.Port() == "" {
+		return nil, fmt.Errorf("UDP tracker URL %q has no port", u)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "udp", u.Host)
+}
+
+// udpConnect returns a connection ID, reusing the last one until it expires.
+func (c *Client) udpConnect(ctx context.Context, conn net.Conn) (uint64, error) {
+	c.mu.Lock()
+	id, valid := c.udp.connectionID, time.Now().Before(c.udp.expires)
+	c.mu.Unlock()
+	if valid {
+		return id, nil
+	}
+
+	body, err := c.udpRoundTrip(ctx, conn, udpActionConnect, false, func(b []byte) []byte {
+		b = binary.BigEndian.AppendUint64(b, udpProtocolID)
+		b = binary.BigEndian.AppendUint32(b, udpActionConnect)
+		return binary.BigEndian.AppendUint32(b, 0) // transaction ID
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(body) < 8 {
+		return 0, fmt.Errorf("short UDP connect response of %d bytes", len(body)+8)
+	}
+	id = binary.BigEndian.Uint64(body)
+
+	c.mu.Lock()
+	c.udp.connectionID = id
+	c.udp.expires = time.Now().Add(udpConnectionIDLifetime)
+	c.mu.Unlock()
+	return id, nil
+}
+
+// udpRoundTrip sends the request build appends to its argument and returns
+// the body of the response, what follows the action and transaction ID.
+// The transaction ID is filled in at bytes 12 to 16 of the request, and if
+// connected is set a connection ID from udpConnect at bytes 0 to 8. The
+// connection ID is checked before every send, so that a request sent again
+// after the ID expired carries a new one. Responses to other transactions
+// are ignored, and the request is sent again with exponential backoff until
+// a response arrives.
+func (c *Client) udpRoundTrip(ctx context.Context, conn net.Conn, action uint32, connected bool, build func([]byte) []byte) ([]byte, error) {
+	req := build(make([]byte, 0, 128))
+	txID := rand.Uint32()
+	binary.BigEndian.PutUint32(req[12:], txID)
+
+	// wake up the read below when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, maxUDPPacket)
+	for n := 0; n <= udpMaxRetries; n++ {
+		if connected {
+			connectionID, err := c.udpConnect(ctx, conn)
+			if err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint64(req, connectionID)
+		}
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(udpTimeout << n)
+		d, ok := ctx.Deadline()
+		last := ok && d.Before(deadline)
+		if last {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for {
+			m, err := conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if last {
+					// ctx will be done any moment now
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			resp := buf[:m]
+			if m < 8 || binary.BigEndian.Uint32(resp[4:]) != txID {
+				continue
+			}
+			switch got := binary.BigEndian.Uint32(resp); got {
+			case action:
+				return append([]byte(nil), resp[8:]...), nil
+			case udpActionError:
+				// the connection ID may be what the tracker didn't like
+				c.mu.Lock()
+				c.udp.expires = time.Time{}
+				c.mu.Unlock()
+				return nil, &FailureError{string(resp[8:])}
+			default:
+				return nil, fmt.Errorf("UDP tracker responded with action %d to action %d", got, action)
+			}
+		}
+	}
+	return nil, fmt.Errorf("UDP tracker did not respond after %d attempts", udpMaxRetries+1)
+}
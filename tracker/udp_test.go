@@ -0,0 +1,369 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filipochnik/btget/torrent"
+)
+
+const testConnectionID = 0x0123456789abcdef
+
+// udpTracker is a stand-in UDP tracker. Before each response it sends one
+// for another transaction, which clients must ignore.
+type udpTracker struct {
+	conn net.PacketConn
+	ipv6 bool // listening on IPv6, announces return IPv6 peers
+
+	mu        sync.Mutex
+	connects  int
+	requests  [][]byte // announces and scrapes
+	drop      int      // number of requests to ignore
+	silent    bool     // ignore all requests
+	failure   string   // respond to announces with this error
+	lastEvent uint32
+	// lifetime, if set, is how long a connection ID is accepted after the
+	// last connect. Requests with an older one are counted in stale and
+	// ignored.
+	lifetime  time.Duration
+	connected time.Time
+	stale     int
+}
+
+func startUDPTracker(t *testing.T) (*udpTracker, string) {
+	return listenUDPTracker(t, "127.0.0.1:0")
+}
+
+func listenUDPTracker(t *testing.T, addr string) (*udpTracker, string) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &udpTracker{conn: conn, ipv6: conn.LocalAddr().(*net.UDPAddr).IP.To4() == nil}
+	t.Cleanup(func() { conn.Close() })
+	go tr.serve()
+	return tr, "udp://" + conn.LocalAddr().String() + "/announce"
+}
+
+func (tr *udpTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := tr.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := tr.handle(buf[:n]); resp != nil {
+			decoy := append([]byte(nil), resp[:8]...)
+			decoy[4]++
+			tr.conn.WriteTo(decoy, addr)
+			tr.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (tr *udpTracker) handle(req []byte) []byte {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.silent || len(req) < 16 {
+		return nil
+	}
+	if tr.drop > 0 {
+		tr.drop--
+		return nil
+	}
+	connectionID := binary.BigEndian.Uint64(req)
+	action := binary.BigEndian.Uint32(req[8:])
+	resp := append([]byte(nil), req[8:16]...)
+
+	switch {
+	case action == udpActionConnect && connectionID == udpProtocolID:
+		tr.connects++
+		tr.connected = time.Now()
+		return binary.BigEndian.AppendUint64(resp, testConnectionID)
+	case tr.lifetime > 0 && time.Since(tr.connected) > tr.lifetime:
+		tr.stale++
+		return nil
+	case connectionID != testConnectionID:
+		return udpError(resp, "bad connection ID")
+	case action == udpActionAnnounce && len(req) == 98:
+		tr.requests = append(tr.requests, req)
+		tr.lastEvent = binary.BigEndian.Uint32(req[80:])
+		if tr.failure != "" {
+			return udpError(resp, tr.failure)
+		}
+		resp = binary.BigEndian.AppendUint32(resp, 1800) // interval
+		resp = binary.BigEndian.AppendUint32(resp, 2)    // leechers
+		resp = binary.BigEndian.AppendUint32(resp, 3)    // seeders
+		if tr.ipv6 {
+			resp = append(resp, net.ParseIP("2001:db8::1")...)
+			return append(resp, 0x1a, 0xe1)
+		}
+		return append(resp, 10, 0, 0, 1, 0x1a, 0xe1)
+	case action == udpActionScrape:
+		tr.requests = append(tr.requests, req)
+		for i := 0; i < (len(req)-16)/20; i++ {
+			resp = binary.BigEndian.AppendUint32(resp, uint32(i+1)) // seeders
+			resp = binary.BigEndian.AppendUint32(resp, 10)          // completed
+			resp = binary.BigEndian.AppendUint32(resp, uint32(i))   // leechers
+		}
+		return resp
+	}
+	return udpError(resp, "bad request")
+}
+
+func udpError(resp []byte, msg string) []byte {
+	binary.BigEndian.PutUint32(resp, udpActionError)
+	return append(resp, msg...)
+}
+
+func (tr *udpTracker) stats() (connects, requests int) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.connects, len(tr.requests)
+}
+
+// fastUDP makes retransmissions quick for the duration of a test.
+func fastUDP(t *testing.T) {
+	timeout, lifetime := udpTimeout, udpConnectionIDLifetime
+	udpTimeout = 10 * time.Millisecond
+	t.Cleanup(func() {
+		udpTimeout, udpConnectionIDLifetime = timeout, lifetime
+	})
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	tr, u := startUDPTracker(t)
+	c := NewClient(u)
+	req := torrent.AnnounceRequest{
+		Port:       6881,
+		Uploaded:   1,
+		Downloaded: 2,
+		Left:       3,
+		Event:      torrent.EventStarted,
+	}
+	copy(req.InfoHash[:], "infohashinfohashinfo")
+	copy(req.PeerID[:], "-GT0001-abcdefghijkl")
+	resp, err := c.Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := &torrent.AnnounceResponse{
+		Interval:   1800,
+		Incomplete: 2,
+		Complete:   3,
+		Peers:      torrent.Peers{{IP: net.IPv4(10, 0, 0, 1), Port: 6881}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("wanted %+v got %+v", want, resp)
+	}
+
+	tr.mu.Lock()
+	r := tr.requests[0]
+	tr.mu.Unlock()
+	if string(r[16:36]) != "infohashinfohashinfo" || string(r[36:56]) != "-GT0001-abcdefghijkl" ||
+		binary.BigEndian.Uint64(r[56:]) != 2 || binary.BigEndian.Uint64(r[64:]) != 3 ||
+		binary.BigEndian.Uint64(r[72:]) != 1 || binary.BigEndian.Uint32(r[80:]) != 2 ||
+		int32(binary.BigEndian.Uint32(r[92:])) != -1 || binary.BigEndian.Uint16(r[96:]) != 6881 {
+		t.Fatalf("wrong announce request %x", r)
+	}
+
+	// the connection ID is reused
+	req.Event = torrent.EventEmpty
+	if _, err := c.Announce(context.Background(), req); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if connects, announces := tr.stats(); connects != 1 || announces != 2 {
+		t.Fatalf("wanted 1 connect and 2 announces, got %d and %d", connects, announces)
+	}
+	tr.mu.Lock()
+	event := tr.lastEvent
+	tr.mu.Unlock()
+	if event != 0 {
+		t.Fatalf("wrong event %d", event)
+	}
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	if ln, err := net.ListenPacket("udp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	} else {
+		ln.Close()
+	}
+	_, u := listenUDPTracker(t, "[::1]:0")
+	var req torrent.AnnounceRequest
+	resp, err := NewClient(u).Announce(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	want := torrent.Peers{{IP: net.ParseIP("2001:db8::1"), Port: 6881}}
+	if !reflect.DeepEqual(resp.Peers, want) {
+		t.Fatalf("wanted peers %v got %v", want, resp.Peers)
+	}
+}
+
+func TestUDPConnectionIDExpiry(t *testing.T) {
+	fastUDP(t)
+	udpConnectionIDLifetime = 0
+	tr, u := startUDPTracker(t)
+	c := NewClient(u)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Announce(context.Background(), torrent.AnnounceRequest{}); err != nil {
+			t.Fatalf("Announce failed: %v", err)
+		}
+	}
+	if connects, _ := tr.stats(); connects != 2 {
+		t.Fatalf("wanted 2 connects, got %d", connects)
+	}
+}
+
+func TestUDPConnectionIDExpiryWhileRetransmitting(t *testing.T) {
+	fastUDP(t)
+	udpTimeout = 20 * time.Millisecond
+	udpConnectionIDLifetime = 30 * time.Millisecond
+	tr, u := startUDPTracker(t)
+	tr.mu.Lock()
+	tr.lifetime = 45 * time.Millisecond
+	tr.mu.Unlock()
+	c := NewClient(u)
+	if _, err := c.Announce(context.Background(), torrent.AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+
+	// the announce is sent at 0, 20 and 60ms, by when the connection ID
+	// has expired
+	tr.mu.Lock()
+	tr.drop = 2
+	tr.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Announce(ctx, torrent.AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.connects != 2 || tr.stale != 0 {
+		t.Fatalf("wanted 2 connects and no stale connection IDs, got %d and %d", tr.connects, tr.stale)
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	fastUDP(t)
+	tr, u := startUDPTracker(t)
+	tr.mu.Lock()
+	tr.drop = 3 // the connect, then the announce twice
+	tr.mu.Unlock()
+	start := time.Now()
+	if _, err := NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	// 10ms, then 10ms and 20ms
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("retransmitted too early, after %v", d)
+	}
+	if connects, announces := tr.stats(); connects != 1 || announces != 1 {
+		t.Fatalf("wanted 1 connect and 1 announce, got %d and %d", connects, announces)
+	}
+}
+
+func TestUDPErrors(t *testing.T) {
+	fastUDP(t)
+	tr, u := startUDPTracker(t)
+	c := NewClient(u)
+	tr.mu.Lock()
+	tr.failure = "torrent not registered"
+	tr.mu.Unlock()
+	_, err := c.Announce(context.Background(), torrent.AnnounceRequest{})
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "torrent not registered" {
+		t.Fatalf("expected FailureError, got %v", err)
+	}
+	// an error makes the client connect again
+	tr.mu.Lock()
+	tr.failure = ""
+	tr.mu.Unlock()
+	if _, err := c.Announce(context.Background(), torrent.AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce failed: %v", err)
+	}
+	if connects, _ := tr.stats(); connects != 2 {
+		t.Fatalf("wanted 2 connects, got %d", connects)
+	}
+
+	tr.mu.Lock()
+	tr.silent = true
+	tr.mu.Unlock()
+	udpTimeout = time.Millisecond // all attempts take 2^9 times this
+	_, err = NewClient(u).Announce(context.Background(), torrent.AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "did not respond after 9 attempts") {
+		t.Fatalf("expected no response error, got %v", err)
+	}
+
+	udpTimeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = NewClient(u).Announce(ctx, torrent.AnnounceRequest{})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	_, err = NewClient("udp://127.0.0.1/announce").Announce(context.Background(), torrent.AnnounceRequest{})
+	if err == nil || !strings.Contains(err.Error(), "no port") {
+		t.Fatalf("expected no port error, got %v", err)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	tr, u := startUDPTracker(t)
+	c := NewClient(u)
+	hashes := [][20]byte{{1}, {2}, {3}}
+	stats, err := c.Scrape(context.Background(), hashes...)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	want := map[[20]byte]ScrapeStats{
+		{1}: {Complete: 1, Downloaded: 10, Incomplete: 0},
+		{2}: {Complete: 2, Downloaded: 10, Incomplete: 1},
+		{3}: {Complete: 3, Downloaded: 10, Incomplete: 2},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("wanted %v got %v", want, stats)
+	}
+	tr.mu.Lock()
+	r := tr.requests[0]
+	tr.mu.Unlock()
+	if len(r) != 16+3*20 || r[16] != 1 || r[36] != 2 || r[56] != 3 {
+		t.Fatalf("wrong scrape request %x", r)
+	}
+
+	// more torrents than fit in a packet are scraped in batches
+	hashes = make([][20]byte, 100)
+	for i := range hashes {
+		hashes[i][0] = byte(i)
+	}
+	stats, err = c.Scrape(context.Background(), hashes...)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	if len(stats) != 100 || stats[hashes[80]].Complete != 80-74+1 {
+		t.Fatalf("wrong stats %v", stats)
+	}
+	tr.mu.Lock()
+	r = tr.requests[2]
+	tr.mu.Unlock()
+	if _, requests := tr.stats(); requests != 3 || len(r) != 16+26*20 {
+		t.Fatalf("wanted batches of 74 and 26, got %d requests", requests)
+	}
+
+	parsed, _ := url.Parse(u)
+	_, err = c.scrapeUDP(context.Background(), parsed, make([][20]byte, 75))
+	if err == nil || !strings.Contains(err.Error(), "more than 74") {
+		t.Fatalf("expected too many error, got %v", err)
+	}
+}